@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cqplugin "github.com/Genos0820/cq-k8s-custom/plugin"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON or YAML plugin spec (defaults to environment variables)")
+	status := flag.Bool("status", false, "list migrations and whether they were applied, without applying any")
+	flag.Parse()
+
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var spec any = map[string]interface{}{}
+	if *configPath != "" {
+		b, err := os.ReadFile(*configPath)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to read config")
+		}
+		spec = b
+	}
+
+	if *status {
+		if err := cqplugin.PrintMigrations(ctx, spec, os.Stdout); err != nil {
+			logger.Fatal().Err(err).Msg("failed to read migrations")
+		}
+		return
+	}
+	if err := cqplugin.Migrate(ctx, logger, spec); err != nil {
+		logger.Fatal().Err(err).Msg("migration failed")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	cqplugin "github.com/Genos0820/cq-k8s-custom/plugin"
+	"github.com/rs/zerolog"
+)
+
+func main() {
+	configPath := flag.String("config", "", "path to a JSON or YAML plugin spec (defaults to environment variables)")
+	flag.Parse()
+
+	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var spec any = map[string]interface{}{}
+	if *configPath != "" {
+		b, err := os.ReadFile(*configPath)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to read config")
+		}
+		spec = b
+	}
+
+	if err := cqplugin.Watch(ctx, logger, spec); err != nil {
+		logger.Fatal().Err(err).Msg("watch failed")
+	}
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultBatchSize is the number of rows written per round trip.
+const DefaultBatchSize = 1000
+
+// maxNamedObjects bounds how many objects a BatchError lists in its message.
+const maxNamedObjects = 10
+
+// BatchWriter buffers resolved resources per table and upserts them in
+// batches, one round trip per batch instead of one per object.
+type BatchWriter struct {
+	store *Store
+	size  int
+
+	mu      sync.Mutex
+	pending map[string][]*schema.Resource
+	counts  map[string]WriteCounts
+}
+
+// NewBatchWriter returns a writer that sends a table's rows once size of
+// them are queued.
+func (s *Store) NewBatchWriter(size int) *BatchWriter {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	return &BatchWriter{
+		store:   s,
+		size:    size,
+		pending: map[string][]*schema.Resource{},
+		counts:  map[string]WriteCounts{},
+	}
+}
+
+// Add queues resource and writes its table's batch once it is full.
+func (w *BatchWriter) Add(ctx context.Context, resource *schema.Resource) error {
+	table := resource.Table.Name
+	w.mu.Lock()
+	w.pending[table] = append(w.pending[table], resource)
+	var batch []*schema.Resource
+	if len(w.pending[table]) >= w.size {
+		batch = w.pending[table]
+		delete(w.pending, table)
+	}
+	w.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+	return w.write(ctx, table, batch)
+}
+
+// Flush writes the rows still queued for table.
+func (w *BatchWriter) Flush(ctx context.Context, table string) error {
+	w.mu.Lock()
+	batch := w.pending[table]
+	delete(w.pending, table)
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return w.write(ctx, table, batch)
+}
+
+// Counts returns how many rows of table were inserted and updated so far.
+func (w *BatchWriter) Counts(table string) WriteCounts {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.counts[table]
+}
+
+func (w *BatchWriter) write(ctx context.Context, table string, batch []*schema.Resource) error {
+	counts, err := w.store.UpsertResources(ctx, batch)
+	w.mu.Lock()
+	total := w.counts[table]
+	total.add(counts)
+	w.counts[table] = total
+	w.mu.Unlock()
+	return err
+}
+
+// BatchError reports the objects of a batch that could not be written.
+type BatchError struct {
+	Table string
+	// Objects names every object that failed, e.g. "default/nginx".
+	Objects []string
+	// Err is the error of the first failed object.
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	names := e.Objects
+	if len(names) > maxNamedObjects {
+		names = append(names[:maxNamedObjects:maxNamedObjects], fmt.Sprintf("and %d more", len(e.Objects)-maxNamedObjects))
+	}
+	return fmt.Sprintf("upsert %s: %d objects failed (%s): %v", e.Table, len(e.Objects), strings.Join(names, ", "), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// UpsertResources writes resources of one table in a single round trip and
+// counts the rows it inserted and updated. The batch runs as one
+// transaction, so if it fails every row is retried on its own and the
+// returned *BatchError names the objects that still fail.
+func (s *Store) UpsertResources(ctx context.Context, resources []*schema.Resource) (counts WriteCounts, err error) {
+	if len(resources) == 0 {
+		return WriteCounts{}, nil
+	}
+	table := resources[0].Table
+
+	ctx, span := StartSpan(ctx, "Store.UpsertResources", TableKey.String(table.Name), RowsKey.Int(len(resources)))
+	started := time.Now()
+	defer func() {
+		recordWrite(ctx, table.Name, started, err)
+		EndSpan(span, err)
+	}()
+
+	batch := &pgx.Batch{}
+	for _, resource := range resources {
+		s.queueUpsert(batch, resource, &counts)
+	}
+	err = s.run(ctx, func(db querier) error {
+		return db.SendBatch(ctx, batch).Close()
+	})
+	if err == nil {
+		return counts, nil
+	}
+	if ctx.Err() != nil {
+		return WriteCounts{}, fmt.Errorf("upsert %s: %w", table.Name, err)
+	}
+
+	// The batch was rolled back; only the rows written one by one count.
+	counts = WriteCounts{}
+	var failed *BatchError
+	for _, resource := range resources {
+		written, err := s.upsertResource(ctx, resource)
+		if err != nil {
+			if failed == nil {
+				failed = &BatchError{Table: table.Name, Err: err}
+			}
+			failed.Objects = append(failed.Objects, objectName(resource))
+			continue
+		}
+		counts.add(written)
+	}
+	if failed != nil {
+		return counts, failed
+	}
+	return counts, nil
+}
+
+// objectName describes resource in error messages: namespace/name for
+// namespaced objects, the name otherwise, falling back to the primary key.
+func objectName(resource *schema.Resource) string {
+	name := columnString(resource, "name")
+	if name == "" {
+		name = columnString(resource, "cluster_name")
+	} else if namespace := columnString(resource, "namespace"); namespace != "" {
+		name = namespace + "/" + name
+	}
+	if name != "" {
+		return name
+	}
+
+	keys := resource.Table.PrimaryKeys()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = columnString(resource, key)
+	}
+	return strings.Join(values, "/")
+}
+
+func columnString(resource *schema.Resource, column string) string {
+	if resource.Table.Columns.Get(column) == nil {
+		return ""
+	}
+	value := resource.Get(column)
+	if value == nil || !value.IsValid() {
+		return ""
+	}
+	return value.String()
+}
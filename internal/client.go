@@ -2,22 +2,49 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"net/http"
+	"time"
 
 	apiextensionsclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
 type Client struct {
 	Clientset              *kubernetes.Clientset
 	ApiextensionsClientset apiextensionsclientset.Interface
 	Config                 *rest.Config
-	id                     string
-	context                string
+	// Kubeconfig is where the context was loaded from.
+	Kubeconfig Kubeconfig
+	// InCluster is set when the client uses the pod's service account.
+	InCluster bool
+	// ClusterUID identifies the cluster behind this context in every table;
+	// ClusterUIDSource tells where it came from, e.g. ClusterUIDKubeSystem.
+	ClusterUID       string
+	ClusterUIDSource string
+	// Store is set when rows should also be written directly to Postgres.
+	Store *Store
+	// Writer batches the writes to Store during a sync.
+	Writer *BatchWriter
+	// PageSize is the Limit used for paginated list calls.
+	PageSize int64
+	// Tags are stored with the cluster row of this context.
+	Tags map[string]string
+	// Namespaces limits namespaced resources; see NamespaceScope.
+	Namespaces NamespaceFilter
+	// Selectors narrow the list and watch calls per resource name.
+	Selectors  map[string]Selector
+	namespaces namespaceScope
+	// Versions is set when resources should be fetched incrementally.
+	Versions *ResourceVersions
+	// SyncID and SyncedAt identify the sync run rows are stamped with.
+	SyncID   string
+	SyncedAt time.Time
+	id       string
+	context  string
 }
 
 // ID returns the unique identifier for this client
@@ -25,37 +52,87 @@ func (c *Client) ID() string {
 	return c.id
 }
 
+// Context returns the kube context this client talks to
+func (c *Client) Context() string {
+	return c.context
+}
+
 // Close closes the client connection
 func (c *Client) Close(ctx context.Context) error {
 	return nil
 }
 
-// NewForContext creates a new Kubernetes client for a specific context
-func NewForContext(ctx context.Context, kubeContext string) (*Client, error) {
-	home := homedir.HomeDir()
-	kubeconfig := filepath.Join(home, ".kube", "config")
+// Impersonation is the identity requests are made as instead of the one the
+// credentials belong to, e.g. a dedicated read-only user.
+type Impersonation struct {
+	User   string              `json:"user,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
 
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadingRules.ExplicitPath = kubeconfig
-	configOverrides := &clientcmd.ConfigOverrides{
-		CurrentContext: kubeContext,
+// IsZero reports whether no impersonation is configured.
+func (i Impersonation) IsZero() bool {
+	return i.User == "" && len(i.Groups) == 0 && len(i.Extra) == 0
+}
+
+// Validate checks that groups and extra come with a user, as the API server
+// requires.
+func (i Impersonation) Validate() error {
+	if i.User == "" && (len(i.Groups) > 0 || len(i.Extra) > 0) {
+		return errors.New("impersonate: groups and extra require a user")
 	}
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+	return nil
+}
 
-	config, err := clientConfig.ClientConfig()
-	if err != nil {
-		return nil, err
+// ClientOptions adjust the rest.Config of a client.
+type ClientOptions struct {
+	// Impersonate replaces any impersonation set in the kubeconfig.
+	Impersonate Impersonation
+	// QPS and Burst limit the request rate; zero keeps client-go's defaults.
+	QPS   float32
+	Burst int
+	// RequestTimeout bounds every request except watches; zero disables it.
+	RequestTimeout time.Duration
+	Retry          RetryPolicy
+}
+
+func (o ClientOptions) apply(config *rest.Config) {
+	if o.QPS > 0 {
+		config.QPS = o.QPS
+	}
+	if o.Burst > 0 {
+		config.Burst = o.Burst
+	}
+	// Installed even with retries and the timeout off, so that client-go's
+	// own Retry-After retries never run on top of the policy.
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &retryTransport{next: rt, policy: o.Retry, timeout: o.RequestTimeout}
+	})
+	if !o.Impersonate.IsZero() {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: o.Impersonate.User,
+			Groups:   o.Impersonate.Groups,
+			Extra:    o.Impersonate.Extra,
+		}
 	}
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
+// NewForContext creates a new Kubernetes client for a specific context
+func NewForContext(ctx context.Context, kubeconfig Kubeconfig, kubeContext string, options ClientOptions) (*Client, error) {
+	loadingRules, err := kubeconfig.loadingRules()
 	if err != nil {
 		return nil, err
 	}
+	configOverrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
 
-	apiextensionsClientset, err := apiextensionsclientset.NewForConfig(config)
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
+	options.apply(config)
 
 	// Resolve the actual context name if empty
 	actualContext := kubeContext
@@ -66,27 +143,46 @@ func NewForContext(ctx context.Context, kubeContext string) (*Client, error) {
 		}
 	}
 
+	client, err := newClient(config, actualContext)
+	if err != nil {
+		return nil, err
+	}
+	client.Kubeconfig = kubeconfig
+	return client, nil
+}
+
+// newClient creates the clientsets for config, named contextName.
+func newClient(config *rest.Config, contextName string) (*Client, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	apiextensionsClientset, err := apiextensionsclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
 		Clientset:              clientset,
 		ApiextensionsClientset: apiextensionsClientset,
 		Config:                 config,
-		id:                     actualContext,
-		context:                actualContext,
+		id:                     contextName,
+		context:                contextName,
 	}, nil
 }
 
 // New creates a new Kubernetes client for the default context
-func New(ctx context.Context) (*Client, error) {
-	return NewForContext(ctx, "")
+func New(ctx context.Context, kubeconfig Kubeconfig) (*Client, error) {
+	return NewForContext(ctx, kubeconfig, "", ClientOptions{})
 }
 
 // GetAvailableContexts returns all available Kubernetes contexts
-func GetAvailableContexts() ([]string, error) {
-	home := homedir.HomeDir()
-	kubeconfig := filepath.Join(home, ".kube", "config")
-
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadingRules.ExplicitPath = kubeconfig
+func GetAvailableContexts(kubeconfig Kubeconfig) ([]string, error) {
+	loadingRules, err := kubeconfig.loadingRules()
+	if err != nil {
+		return nil, err
+	}
 	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
 
 	config, err := clientConfig.RawConfig()
@@ -101,12 +197,27 @@ func GetAvailableContexts() ([]string, error) {
 	return contexts, nil
 }
 
-func GetContextDetails(contextName string) (string, string, error) {
-	home := homedir.HomeDir()
-	kubeconfig := filepath.Join(home, ".kube", "config")
+// GetCurrentContext returns the kubeconfig's current context, "" if it has
+// none.
+func GetCurrentContext(kubeconfig Kubeconfig) (string, error) {
+	loadingRules, err := kubeconfig.loadingRules()
+	if err != nil {
+		return "", err
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+
+	config, err := clientConfig.RawConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.CurrentContext, nil
+}
 
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	loadingRules.ExplicitPath = kubeconfig
+func GetContextDetails(kubeconfig Kubeconfig, contextName string) (string, string, error) {
+	loadingRules, err := kubeconfig.loadingRules()
+	if err != nil {
+		return "", "", err
+	}
 	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
 
 	config, err := clientConfig.RawConfig()
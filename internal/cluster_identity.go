@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Sources of a cluster_uid.
+const (
+	// ClusterUIDOverride is a cluster_uid set in the spec.
+	ClusterUIDOverride = "override"
+	// ClusterUIDKubeSystem is the UID of the kube-system namespace, which
+	// lives as long as the cluster does.
+	ClusterUIDKubeSystem = "kube-system"
+	// ClusterUIDAlias is the cluster_uid a previous sync of the same context
+	// recorded for the server, used when kube-system cannot be read.
+	ClusterUIDAlias = "alias"
+	// ClusterUIDServer is a hash of the API server address, as older
+	// releases used.
+	ClusterUIDServer = "server"
+)
+
+// clusterAliasesSQL creates the table mapping the IDs a cluster was stored
+// under before to its current cluster_uid.
+const clusterAliasesSQL = `
+CREATE TABLE IF NOT EXISTS k8s_cluster_aliases (
+	alias_uid TEXT PRIMARY KEY,
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	server TEXT,
+	first_seen_at TIMESTAMPTZ NOT NULL,
+	last_seen_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS k8s_cluster_aliases_cluster_uid_idx ON k8s_cluster_aliases (cluster_uid);
+ALTER TABLE k8s_clusters ADD COLUMN IF NOT EXISTS uid_source TEXT;
+ALTER TABLE IF EXISTS k8s_clusters_history ADD COLUMN IF NOT EXISTS uid_source TEXT;
+`
+
+// KubeSystemUID returns the UID of the kube-system namespace.
+func (c *Client) KubeSystemUID(ctx context.Context) (string, error) {
+	namespace, err := c.Clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get kube-system namespace: %w", err)
+	}
+	if namespace.UID == "" {
+		return "", errors.New("kube-system namespace has no uid")
+	}
+	return string(namespace.UID), nil
+}
+
+// ServerUID hashes the API server address of c into a UUID, falling back to
+// the context name when there is no address.
+func ServerUID(c *Client) string {
+	if c.Config != nil && c.Config.Host != "" {
+		return uuid.NewSHA1(uuid.NameSpaceURL, []byte(c.Config.Host)).String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("context:"+c.Context())).String()
+}
+
+// SharedServer reports whether the API server address of c is one many
+// clusters use, so it cannot tell them apart: the in-cluster service address
+// and loopback hosts of kind or tunnelled clusters.
+func SharedServer(c *Client) bool {
+	if c.InCluster {
+		return true
+	}
+	if c.Config == nil || c.Config.Host == "" {
+		return false
+	}
+	host := c.Config.Host
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ClusterAlias returns the cluster_uid recorded for alias by contextName, or
+// "" when none is. Clusters behind the same address are only told apart by
+// their context, so an alias recorded by another context is not used.
+func (s *Store) ClusterAlias(ctx context.Context, alias, contextName string) (string, error) {
+	var clusterUID string
+	err := s.run(ctx, func(db querier) error {
+		return db.QueryRow(ctx, `
+SELECT cluster_uid FROM k8s_cluster_aliases WHERE alias_uid = $1 AND context_name = $2;
+`, alias, contextName).Scan(&clusterUID)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read cluster alias %s: %w", alias, err)
+	}
+	return clusterUID, nil
+}
+
+// AdoptClusterAliases records aliases as earlier IDs of clusterUID and moves
+// the rows stored under them in every k8s_ table to clusterUID. An alias
+// already recorded for another cluster is left alone, and so is one that
+// another context still syncs as its cluster_uid. Rows that exist under both
+// IDs keep the clusterUID version. It returns the number of rows moved.
+func (s *Store) AdoptClusterAliases(ctx context.Context, clusterUID string, aliases []string, contextName, server string) (int64, error) {
+	var moved int64
+	err := s.run(ctx, func(db querier) error {
+		now := time.Now()
+		var owned []string
+		for _, alias := range aliases {
+			if alias == "" || alias == clusterUID {
+				continue
+			}
+			var mine bool
+			err := db.QueryRow(ctx, `
+INSERT INTO k8s_cluster_aliases (alias_uid, cluster_uid, context_name, server, first_seen_at, last_seen_at)
+VALUES ($1, $2, $3, $4, $5, $5)
+ON CONFLICT (alias_uid) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at
+RETURNING cluster_uid = $2;
+`, alias, clusterUID, contextName, server, now).Scan(&mine)
+			if err != nil {
+				return fmt.Errorf("record alias %s: %w", alias, err)
+			}
+			if !mine {
+				continue
+			}
+			var inUse bool
+			err = db.QueryRow(ctx, `
+SELECT EXISTS (SELECT 1 FROM k8s_clusters WHERE cluster_uid = $1 AND context_name IS DISTINCT FROM $2);
+`, alias, contextName).Scan(&inUse)
+			if err != nil {
+				return fmt.Errorf("check alias %s: %w", alias, err)
+			}
+			if !inUse {
+				owned = append(owned, alias)
+			}
+		}
+		if len(owned) == 0 {
+			return nil
+		}
+
+		tables, err := clusterUIDTables(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			for _, alias := range owned {
+				count, err := moveClusterRows(ctx, db, table, alias, clusterUID)
+				if err != nil {
+					return err
+				}
+				moved += count
+			}
+		}
+		return nil
+	})
+	return moved, err
+}
+
+// clusterTable is a table with a cluster_uid column and the other columns
+// of its primary key.
+type clusterTable struct {
+	name string
+	keys []string
+}
+
+func clusterUIDTables(ctx context.Context, db querier) ([]clusterTable, error) {
+	rows, err := db.Query(ctx, `
+SELECT c.table_name::text,
+	COALESCE(array_agg(k.column_name::text ORDER BY k.ordinal_position) FILTER (WHERE k.column_name IS NOT NULL), '{}')
+FROM information_schema.columns c
+JOIN information_schema.tables t
+	ON t.table_schema = c.table_schema AND t.table_name = c.table_name AND t.table_type = 'BASE TABLE'
+LEFT JOIN information_schema.table_constraints tc
+	ON tc.table_schema = c.table_schema AND tc.table_name = c.table_name AND tc.constraint_type = 'PRIMARY KEY'
+LEFT JOIN information_schema.key_column_usage k
+	ON k.constraint_schema = tc.constraint_schema AND k.constraint_name = tc.constraint_name AND k.column_name <> 'cluster_uid'
+WHERE c.table_schema = current_schema() AND c.column_name = 'cluster_uid'
+	AND c.table_name LIKE 'k8s\_%' AND c.table_name <> 'k8s_cluster_aliases'
+GROUP BY c.table_name
+ORDER BY c.table_name;
+`)
+	if err != nil {
+		return nil, fmt.Errorf("list cluster tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []clusterTable
+	for rows.Next() {
+		var table clusterTable
+		if err := rows.Scan(&table.name, &table.keys); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// moveClusterRows moves the rows of table from alias to clusterUID,
+// dropping those that clusterUID already has.
+func moveClusterRows(ctx context.Context, db querier, table clusterTable, alias, clusterUID string) (int64, error) {
+	conflict := make([]string, 0, len(table.keys)+1)
+	conflict = append(conflict, "n.cluster_uid = $1")
+	for _, key := range table.keys {
+		conflict = append(conflict, fmt.Sprintf("n.%s = o.%s", pgx.Identifier{key}.Sanitize(), pgx.Identifier{key}.Sanitize()))
+	}
+	name := pgx.Identifier{table.name}.Sanitize()
+
+	tag, err := db.Exec(ctx, fmt.Sprintf(`
+UPDATE %s o SET cluster_uid = $1
+WHERE o.cluster_uid = $2 AND NOT EXISTS (SELECT 1 FROM %s n WHERE %s);
+`, name, name, strings.Join(conflict, " AND ")), clusterUID, alias)
+	if err != nil {
+		return 0, fmt.Errorf("move %s rows to %s: %w", table.name, clusterUID, err)
+	}
+	if _, err := db.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE cluster_uid = $1;`, name), alias); err != nil {
+		return 0, fmt.Errorf("drop %s rows of %s: %w", table.name, alias, err)
+	}
+	return tag.RowsAffected(), nil
+}
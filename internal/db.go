@@ -2,13 +2,30 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Store struct {
+	// Tombstones makes RemoveObject and RemoveStale set deleted_at instead of deleting rows.
+	Tombstones bool
+	// History records every version of a row in its _history table.
+	History bool
+
 	pool *pgxpool.Pool
+	// tx is set on snapshots returned by Begin; txMu serializes its use.
+	tx   pgx.Tx
+	txMu *sync.Mutex
+	// upserts and histories cache the generated statements per table name
+	upserts   *sync.Map
+	histories *sync.Map
 }
 
 func NewStore(ctx context.Context, databaseURL string) (*Store, error) {
@@ -16,185 +33,395 @@ func NewStore(ctx context.Context, databaseURL string) (*Store, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Store{pool: pool}, nil
+	return &Store{pool: pool, upserts: &sync.Map{}, histories: &sync.Map{}}, nil
 }
 
+// Close closes the connection pool. Snapshots are ended with Commit or
+// Rollback instead.
 func (s *Store) Close() {
-	s.pool.Close()
-}
-
-func (s *Store) EnsureSchema(ctx context.Context) error {
-	_, err := s.pool.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS k8s_clusters (
-	cluster_uid TEXT PRIMARY KEY,
-	context_name TEXT,
-	cluster_name TEXT NOT NULL,
-	server TEXT,
-	ca_file TEXT,
-	insecure_skip_verify BOOLEAN DEFAULT FALSE,
-	namespace TEXT DEFAULT 'default',
-	kubernetes_version TEXT,
-	node_count INTEGER,
-	synced_at TIMESTAMPTZ NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	updated_at TIMESTAMPTZ NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS k8s_namespaces (
-	cluster_uid TEXT NOT NULL,
-	context_name TEXT,
-	uid TEXT NOT NULL,
-	name TEXT NOT NULL,
-	status TEXT NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	PRIMARY KEY (cluster_uid, uid),
-	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS k8s_pods (
-	cluster_uid TEXT NOT NULL,
-	context_name TEXT,
-	uid TEXT NOT NULL,
-	namespace TEXT NOT NULL,
-	name TEXT NOT NULL,
-	status TEXT NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	PRIMARY KEY (cluster_uid, uid),
-	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS k8s_deployments (
-	cluster_uid TEXT NOT NULL,
-	context_name TEXT,
-	uid TEXT NOT NULL,
-	namespace TEXT NOT NULL,
-	name TEXT NOT NULL,
-	replicas INTEGER NOT NULL,
-	ready INTEGER NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	PRIMARY KEY (cluster_uid, uid),
-	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS k8s_services (
-	cluster_uid TEXT NOT NULL,
-	context_name TEXT,
-	uid TEXT NOT NULL,
-	namespace TEXT NOT NULL,
-	name TEXT NOT NULL,
-	type TEXT NOT NULL,
-	cluster_ip TEXT NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	PRIMARY KEY (cluster_uid, uid),
-	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
-);
-
-CREATE TABLE IF NOT EXISTS k8s_crds (
-	cluster_uid TEXT NOT NULL,
-	context_name TEXT,
-	uid TEXT NOT NULL,
-	name TEXT NOT NULL,
-	group_name TEXT NOT NULL,
-	kind TEXT NOT NULL,
-	plural TEXT NOT NULL,
-	scope TEXT NOT NULL,
-	created_at TIMESTAMPTZ NOT NULL,
-	PRIMARY KEY (cluster_uid, uid),
-	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
-);
+	if s.pool != nil {
+		s.pool.Close()
+	}
+}
+
+// EnsureSchema brings the database up to date: it applies pending
+// migrations, checks that the columns of tables and ledgers match their
+// definitions, and creates the _history tables of tables when History is
+// set. Ledgers are the run tables, which have no history.
+func (s *Store) EnsureSchema(ctx context.Context, tables, ledgers schema.Tables) error {
+	if _, err := s.Migrate(ctx); err != nil {
+		return err
+	}
+	if err := s.checkColumns(ctx, append(append(schema.Tables{}, tables...), ledgers...)); err != nil {
+		return err
+	}
+	if !s.History {
+		return nil
+	}
+	for _, table := range tables.FlattenTables() {
+		history := HistoryTable(table)
+		if _, err := s.exec(ctx, CreateTableSQL(history)); err != nil {
+			return fmt.Errorf("create %s: %w", history.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkColumns reports columns of tables that the migrations did not create
+// as defined: missing, of another type, with other nullability or primary
+// key. A schema change without a matching migration then fails at start
+// instead of on write.
+func (s *Store) checkColumns(ctx context.Context, tables schema.Tables) error {
+	existing := map[string]dbColumn{}
+	err := s.run(ctx, func(db querier) error {
+		rows, err := db.Query(ctx, `
+SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+	COALESCE(a.attnum = ANY(i.indkey), false)
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+LEFT JOIN pg_index i ON i.indrelid = c.oid AND i.indisprimary
+WHERE c.relnamespace = current_schema()::regnamespace AND c.relkind = 'r'
+	AND a.attnum > 0 AND NOT a.attisdropped;
 `)
-	return err
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var table, column string
+			var info dbColumn
+			if err := rows.Scan(&table, &column, &info.typ, &info.notNull, &info.primaryKey); err != nil {
+				return err
+			}
+			existing[table+"."+column] = info
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("read columns: %w", err)
+	}
+
+	if drift := schemaDrift(tables, existing); len(drift) > 0 {
+		return fmt.Errorf("schema differs after migrations (add a migration): %s", strings.Join(drift, "; "))
+	}
+	return nil
 }
 
-func (s *Store) UpsertCluster(ctx context.Context, clusterUID, contextName, clusterName, server, caFile string, insecureSkipVerify bool, namespace, kubernetesVersion string, nodeCount int64) error {
-	now := time.Now()
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_clusters (cluster_uid, context_name, cluster_name, server, ca_file, insecure_skip_verify, namespace, kubernetes_version, node_count, synced_at, created_at, updated_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-ON CONFLICT (cluster_uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	cluster_name = EXCLUDED.cluster_name,
-	server = EXCLUDED.server,
-	ca_file = EXCLUDED.ca_file,
-	insecure_skip_verify = EXCLUDED.insecure_skip_verify,
-	namespace = EXCLUDED.namespace,
-	kubernetes_version = EXCLUDED.kubernetes_version,
-	node_count = EXCLUDED.node_count,
-	synced_at = EXCLUDED.synced_at,
-	updated_at = EXCLUDED.updated_at;
-`, clusterUID, contextName, clusterName, server, caFile, insecureSkipVerify, namespace, kubernetesVersion, nodeCount, now, now, now)
-	return err
+// dbColumn is a column as Postgres reports it.
+type dbColumn struct {
+	// typ is the type as format_type names it, e.g. "timestamp with time zone".
+	typ        string
+	notNull    bool
+	primaryKey bool
 }
 
-func (s *Store) UpsertNamespace(ctx context.Context, clusterUID, contextName, uid, name, status string, createdAt time.Time) error {
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_namespaces (cluster_uid, context_name, uid, name, status, created_at)
-VALUES ($1, $2, $3, $4, $5, $6)
-ON CONFLICT (cluster_uid, uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	name = EXCLUDED.name,
-	status = EXCLUDED.status,
-	created_at = EXCLUDED.created_at;
-`, clusterUID, contextName, uid, name, status, createdAt)
-	return err
+// schemaDrift compares the columns of tables with existing, keyed by
+// "table.column", and describes every difference.
+func schemaDrift(tables schema.Tables, existing map[string]dbColumn) []string {
+	var drift []string
+	for _, table := range tables.FlattenTables() {
+		for _, column := range table.Columns {
+			name := table.Name + "." + column.Name
+			got, ok := existing[name]
+			if !ok {
+				drift = append(drift, name+" is missing")
+				continue
+			}
+			if want := pgTypeName(column); got.typ != want {
+				drift = append(drift, fmt.Sprintf("%s is %s, want %s", name, got.typ, want))
+			}
+			if want := column.PrimaryKey || column.NotNull; got.notNull != want {
+				drift = append(drift, fmt.Sprintf("%s has NOT NULL %v, want %v", name, got.notNull, want))
+			}
+			if got.primaryKey != column.PrimaryKey {
+				drift = append(drift, fmt.Sprintf("%s is in the primary key %v, want %v", name, got.primaryKey, column.PrimaryKey))
+			}
+		}
+	}
+	return drift
 }
 
-func (s *Store) UpsertPod(ctx context.Context, clusterUID, contextName, uid, namespace, name, status string, createdAt time.Time) error {
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_pods (cluster_uid, context_name, uid, namespace, name, status, created_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7)
-ON CONFLICT (cluster_uid, uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	namespace = EXCLUDED.namespace,
-	name = EXCLUDED.name,
-	status = EXCLUDED.status,
-	created_at = EXCLUDED.created_at;
-`, clusterUID, contextName, uid, namespace, name, status, createdAt)
-	return err
+// WriteCounts counts the rows upserts inserted and the existing rows they
+// updated.
+type WriteCounts struct {
+	Inserted int64
+	Updated  int64
 }
 
-func (s *Store) UpsertDeployment(ctx context.Context, clusterUID, contextName, uid, namespace, name string, replicas, ready int32, createdAt time.Time) error {
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_deployments (cluster_uid, context_name, uid, namespace, name, replicas, ready, created_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-ON CONFLICT (cluster_uid, uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	namespace = EXCLUDED.namespace,
-	name = EXCLUDED.name,
-	replicas = EXCLUDED.replicas,
-	ready = EXCLUDED.ready,
-	created_at = EXCLUDED.created_at;
-`, clusterUID, contextName, uid, namespace, name, replicas, ready, createdAt)
-	return err
+func (c *WriteCounts) add(o WriteCounts) {
+	c.Inserted += o.Inserted
+	c.Updated += o.Updated
 }
 
-func (s *Store) UpsertService(ctx context.Context, clusterUID, contextName, uid, namespace, name, serviceType, clusterIP string, createdAt time.Time) error {
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_services (cluster_uid, context_name, uid, namespace, name, type, cluster_ip, created_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-ON CONFLICT (cluster_uid, uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	namespace = EXCLUDED.namespace,
-	name = EXCLUDED.name,
-	type = EXCLUDED.type,
-	cluster_ip = EXCLUDED.cluster_ip,
-	created_at = EXCLUDED.created_at;
-`, clusterUID, contextName, uid, namespace, name, serviceType, clusterIP, createdAt)
+// UpsertResource inserts or updates a resolved resource in its table.
+func (s *Store) UpsertResource(ctx context.Context, resource *schema.Resource) error {
+	started := time.Now()
+	_, err := s.upsertResource(ctx, resource)
+	recordWrite(ctx, resource.Table.Name, started, err)
 	return err
 }
 
-func (s *Store) UpsertCRD(ctx context.Context, clusterUID, contextName, uid, name, groupName, kind, plural, scope string, createdAt time.Time) error {
-	_, err := s.pool.Exec(ctx, `
-INSERT INTO k8s_crds (cluster_uid, context_name, uid, name, group_name, kind, plural, scope, created_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-ON CONFLICT (cluster_uid, uid)
-DO UPDATE SET context_name = EXCLUDED.context_name,
-	name = EXCLUDED.name,
-	group_name = EXCLUDED.group_name,
-	kind = EXCLUDED.kind,
-	plural = EXCLUDED.plural,
-	scope = EXCLUDED.scope,
-	created_at = EXCLUDED.created_at;
-`, clusterUID, contextName, uid, name, groupName, kind, plural, scope, createdAt)
-	return err
+func (s *Store) upsertResource(ctx context.Context, resource *schema.Resource) (WriteCounts, error) {
+	table := resource.Table
+	var counts WriteCounts
+	batch := &pgx.Batch{}
+	s.queueUpsert(batch, resource, &counts)
+	err := s.run(ctx, func(db querier) error {
+		return db.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return WriteCounts{}, fmt.Errorf("upsert %s %s: %w", table.Name, objectName(resource), err)
+	}
+	return counts, nil
+}
+
+// queueUpsert adds the statements writing resource, and its history when
+// enabled, to batch. counts is updated as the batch results are read.
+func (s *Store) queueUpsert(batch *pgx.Batch, resource *schema.Resource, counts *WriteCounts) {
+	batch.Queue(s.upsertSQL(resource.Table), resourceArgs(resource)...).QueryRow(func(row pgx.Row) error {
+		var inserted bool
+		if err := row.Scan(&inserted); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// DO NOTHING on a conflict returns no row.
+				return nil
+			}
+			return err
+		}
+		if inserted {
+			counts.Inserted++
+		} else {
+			counts.Updated++
+		}
+		return nil
+	})
+	if s.History {
+		s.queueHistory(batch, resource)
+	}
+}
+
+// upsertSQL returns the cached upsert statement for table.
+func (s *Store) upsertSQL(table *schema.Table) string {
+	query, ok := s.upserts.Load(table.Name)
+	if !ok {
+		query, _ = s.upserts.LoadOrStore(table.Name, UpsertSQL(table))
+	}
+	return query.(string)
+}
+
+// StaleObjects returns the UIDs of the live rows of table for clusterUID that
+// the sync run syncID did not write, outside keepNamespaces, i.e. what
+// RemoveStale is about to remove.
+func (s *Store) StaleObjects(ctx context.Context, table, clusterUID, syncID string, keepNamespaces []string) ([]string, error) {
+	where, args := staleWhere([]any{clusterUID, syncID}, keepNamespaces)
+	var uids []string
+	err := s.run(ctx, func(db querier) error {
+		rows, err := db.Query(ctx, fmt.Sprintf(`
+SELECT uid::text FROM %s
+WHERE %s AND deleted_at IS NULL;
+`, table, where), args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var uid string
+			if err := rows.Scan(&uid); err != nil {
+				return err
+			}
+			uids = append(uids, uid)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read stale %s: %w", table, err)
+	}
+	return uids, nil
+}
+
+// DeleteStale removes the rows of table for clusterUID that the sync run
+// syncID did not write, outside keepNamespaces.
+func (s *Store) DeleteStale(ctx context.Context, table, clusterUID, syncID string, keepNamespaces []string) (int64, error) {
+	where, args := staleWhere([]any{clusterUID, syncID}, keepNamespaces)
+	tag, err := s.exec(ctx, fmt.Sprintf(`
+DELETE FROM %s
+WHERE %s;
+`, table, where), args...)
+	if err != nil {
+		return 0, fmt.Errorf("delete stale %s: %w", table, err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// TombstoneStale sets deleted_at on the rows of table for clusterUID that the
+// sync run syncID did not write, outside keepNamespaces. Rows seen again later
+// are revived by the upsert.
+func (s *Store) TombstoneStale(ctx context.Context, table, clusterUID, syncID string, keepNamespaces []string, deletedAt time.Time) (int64, error) {
+	where, args := staleWhere([]any{clusterUID, syncID, deletedAt}, keepNamespaces)
+	tag, err := s.exec(ctx, fmt.Sprintf(`
+UPDATE %s SET deleted_at = $3
+WHERE %s AND deleted_at IS NULL;
+`, table, where), args...)
+	if err != nil {
+		return 0, fmt.Errorf("tombstone stale %s: %w", table, err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RemoveStale deletes or tombstones the rows of table for clusterUID that the
+// sync run syncID did not write. Rows in keepNamespaces are left alone, e.g.
+// because only their changes were fetched.
+func (s *Store) RemoveStale(ctx context.Context, table, clusterUID, syncID string, keepNamespaces []string, at time.Time) (int64, error) {
+	var (
+		count int64
+		err   error
+	)
+	if s.Tombstones {
+		count, err = s.TombstoneStale(ctx, table, clusterUID, syncID, keepNamespaces, at)
+	} else {
+		count, err = s.DeleteStale(ctx, table, clusterUID, syncID, keepNamespaces)
+	}
+	if err != nil || count == 0 || !s.History {
+		return count, err
+	}
+	return count, s.closeRemovedHistory(ctx, table, clusterUID, at)
+}
+
+// staleWhere selects the rows of cluster $1 not written by sync $2, given
+// args starting with those two, and leaves out the rows in keepNamespaces.
+func staleWhere(args []any, keepNamespaces []string) (string, []any) {
+	where := "cluster_uid = $1 AND sync_id IS DISTINCT FROM $2"
+	if len(keepNamespaces) == 0 {
+		return where, args
+	}
+	args = append(args, keepNamespaces)
+	return fmt.Sprintf("%s AND namespace <> ALL($%d)", where, len(args)), args
+}
+
+// RemoveObject deletes or tombstones a single object.
+func (s *Store) RemoveObject(ctx context.Context, table, clusterUID, uid string, at time.Time) error {
+	var err error
+	if s.Tombstones {
+		err = s.TombstoneObject(ctx, table, clusterUID, uid, at)
+	} else {
+		err = s.DeleteObject(ctx, table, clusterUID, uid)
+	}
+	if err != nil || !s.History {
+		return err
+	}
+	return s.closeObjectHistory(ctx, table, clusterUID, uid, at)
+}
+
+// ObjectKey identifies a stored Kubernetes object.
+type ObjectKey struct {
+	UID       string
+	Namespace string
+	Name      string
+}
+
+// ObjectKeys returns the live (not tombstoned) objects stored for clusterUID.
+func (s *Store) ObjectKeys(ctx context.Context, table *schema.Table, clusterUID string) ([]ObjectKey, error) {
+	namespace := "''"
+	if table.Columns.Get("namespace") != nil {
+		namespace = "namespace"
+	}
+	var keys []ObjectKey
+	err := s.run(ctx, func(db querier) error {
+		rows, err := db.Query(ctx, fmt.Sprintf(`
+SELECT uid::text, %s, name FROM %s
+WHERE cluster_uid = $1 AND deleted_at IS NULL;
+`, namespace, table.Name), clusterUID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key ObjectKey
+			if err := rows.Scan(&key.UID, &key.Namespace, &key.Name); err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", table.Name, err)
+	}
+	return keys, nil
+}
+
+// DeleteObject removes a single object from table.
+func (s *Store) DeleteObject(ctx context.Context, table, clusterUID, uid string) error {
+	_, err := s.exec(ctx, fmt.Sprintf(`
+DELETE FROM %s WHERE cluster_uid = $1 AND uid = $2;
+`, table), clusterUID, uid)
+	if err != nil {
+		return fmt.Errorf("delete %s %s: %w", table, uid, err)
+	}
+	return nil
+}
+
+// TombstoneObject sets deleted_at on a single object in table.
+func (s *Store) TombstoneObject(ctx context.Context, table, clusterUID, uid string, deletedAt time.Time) error {
+	_, err := s.exec(ctx, fmt.Sprintf(`
+UPDATE %s SET deleted_at = $3 WHERE cluster_uid = $1 AND uid = $2 AND deleted_at IS NULL;
+`, table), clusterUID, uid, deletedAt)
+	if err != nil {
+		return fmt.Errorf("tombstone %s %s: %w", table, uid, err)
+	}
+	return nil
+}
+
+// resourceArgs returns the resource values in column order, with nulls as nil.
+func resourceArgs(resource *schema.Resource) []any {
+	values := resource.GetValues()
+	args := make([]any, len(values))
+	for i, value := range values {
+		if value.IsValid() {
+			args[i] = value.Get()
+		}
+	}
+	return args
+}
+
+// CreateTableSQL generates the CREATE TABLE statement for table.
+func CreateTableSQL(table *schema.Table) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s (\n", table.Name)
+	for _, column := range table.Columns {
+		fmt.Fprintf(&b, "\t%s %s", column.Name, pgType(column))
+		if column.PrimaryKey || column.NotNull {
+			b.WriteString(" NOT NULL")
+		}
+		b.WriteString(",\n")
+	}
+	fmt.Fprintf(&b, "\tPRIMARY KEY (%s)\n);", strings.Join(table.PrimaryKeys(), ", "))
+	return b.String()
+}
+
+// UpsertSQL generates an INSERT ... ON CONFLICT statement keyed on the
+// table's primary key. created_at is only written on insert so first-seen
+// timestamps survive later syncs. It returns whether the row was inserted
+// rather than updated.
+func UpsertSQL(table *schema.Table) string {
+	names := table.Columns.Names()
+	placeholders := make([]string, len(names))
+	for i := range names {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	updates := make([]string, 0, len(names))
+	for _, column := range table.Columns {
+		if column.PrimaryKey || column.Name == "created_at" {
+			continue
+		}
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column.Name, column.Name))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)\nON CONFLICT (%s)\n",
+		table.Name, strings.Join(names, ", "), strings.Join(placeholders, ", "), strings.Join(table.PrimaryKeys(), ", "))
+	if len(updates) == 0 {
+		return query + "DO NOTHING\nRETURNING (xmax = 0);"
+	}
+	return query + "DO UPDATE SET " + strings.Join(updates, ",\n\t") + "\nRETURNING (xmax = 0);"
 }
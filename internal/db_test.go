@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/types"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	table := &schema.Table{
+		Name: "k8s_pods",
+		Columns: []schema.Column{
+			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "labels", Type: types.ExtensionTypes.JSON},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		},
+	}
+	matching := map[string]dbColumn{
+		"k8s_pods.uid":        {typ: "uuid", notNull: true, primaryKey: true},
+		"k8s_pods.name":       {typ: "text", notNull: true},
+		"k8s_pods.labels":     {typ: "jsonb"},
+		"k8s_pods.created_at": {typ: "timestamp with time zone", notNull: true},
+	}
+
+	tests := []struct {
+		name   string
+		change func(existing map[string]dbColumn)
+		want   []string
+	}{
+		{
+			name:   "matching schema",
+			change: func(map[string]dbColumn) {},
+		},
+		{
+			name:   "missing column",
+			change: func(existing map[string]dbColumn) { delete(existing, "k8s_pods.labels") },
+			want:   []string{"k8s_pods.labels is missing"},
+		},
+		{
+			name: "type",
+			change: func(existing map[string]dbColumn) {
+				existing["k8s_pods.uid"] = dbColumn{typ: "text", notNull: true, primaryKey: true}
+			},
+			want: []string{"k8s_pods.uid is text, want uuid"},
+		},
+		{
+			name: "nullability",
+			change: func(existing map[string]dbColumn) {
+				existing["k8s_pods.name"] = dbColumn{typ: "text"}
+				existing["k8s_pods.labels"] = dbColumn{typ: "jsonb", notNull: true}
+			},
+			want: []string{
+				"k8s_pods.name has NOT NULL false, want true",
+				"k8s_pods.labels has NOT NULL true, want false",
+			},
+		},
+		{
+			name: "primary key",
+			change: func(existing map[string]dbColumn) {
+				existing["k8s_pods.uid"] = dbColumn{typ: "uuid", notNull: true}
+				existing["k8s_pods.name"] = dbColumn{typ: "text", notNull: true, primaryKey: true}
+			},
+			want: []string{
+				"k8s_pods.uid is in the primary key false, want true",
+				"k8s_pods.name is in the primary key true, want false",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := map[string]dbColumn{}
+			for name, column := range matching {
+				existing[name] = column
+			}
+			tt.change(existing)
+			if got := schemaDrift(schema.Tables{table}, existing); !slices.Equal(got, tt.want) {
+				t.Errorf("schemaDrift() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaleWhere(t *testing.T) {
+	at := time.Now()
+	tests := []struct {
+		name      string
+		args      []any
+		keep      []string
+		wantWhere string
+		wantArgs  []any
+	}{
+		{
+			name:      "whole table",
+			args:      []any{"cluster", "sync"},
+			wantWhere: "cluster_uid = $1 AND sync_id IS DISTINCT FROM $2",
+			wantArgs:  []any{"cluster", "sync"},
+		},
+		{
+			name:      "kept namespaces",
+			args:      []any{"cluster", "sync"},
+			keep:      []string{"default", "payments"},
+			wantWhere: "cluster_uid = $1 AND sync_id IS DISTINCT FROM $2 AND namespace <> ALL($3)",
+			wantArgs:  []any{"cluster", "sync", []string{"default", "payments"}},
+		},
+		{
+			name:      "kept namespaces after other arguments",
+			args:      []any{"cluster", "sync", at},
+			keep:      []string{"default"},
+			wantWhere: "cluster_uid = $1 AND sync_id IS DISTINCT FROM $2 AND namespace <> ALL($4)",
+			wantArgs:  []any{"cluster", "sync", at, []string{"default"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := staleWhere(tt.args, tt.keep)
+			if where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/jackc/pgx/v5"
+)
+
+// HistorySuffix names the companion table keeping every version of a row.
+const HistorySuffix = "_history"
+
+// volatileColumns change on every sync without the object changing, so they
+// are left out of history rows and of the content hash.
+var volatileColumns = map[string]struct{}{
+	"sync_id":    {},
+	"synced_at":  {},
+	"deleted_at": {},
+	"updated_at": {},
+}
+
+// unhashedColumns are kept in history rows but left out of the content hash
+// of a table. The cluster row is sent with created_at set to the sync time
+// and the upsert keeps the first one, so it does not tell versions apart.
+var unhashedColumns = map[string]map[string]struct{}{
+	"k8s_clusters": {"created_at": {}},
+}
+
+// HistoryTable returns the _history companion of table: its content columns
+// plus the valid_from/valid_to range a version was current for. The open
+// version of an object has valid_to NULL.
+func HistoryTable(table *schema.Table) *schema.Table {
+	columns := make(schema.ColumnList, 0, len(table.Columns)+3)
+	for _, column := range historyColumns(table) {
+		columns = append(columns, schema.Column{
+			Name:       column.Name,
+			Type:       column.Type,
+			PrimaryKey: column.PrimaryKey,
+			NotNull:    column.NotNull,
+		})
+	}
+	columns = append(columns,
+		schema.Column{Name: "content_hash", Type: arrow.BinaryTypes.String, NotNull: true},
+		schema.Column{Name: "valid_from", Type: arrow.FixedWidthTypes.Timestamp_ns, PrimaryKey: true},
+		schema.Column{Name: "valid_to", Type: arrow.FixedWidthTypes.Timestamp_ns},
+	)
+	return &schema.Table{Name: table.Name + HistorySuffix, Columns: columns}
+}
+
+// historyColumns returns the columns of table that make up an object's content.
+func historyColumns(table *schema.Table) schema.ColumnList {
+	columns := make(schema.ColumnList, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		if _, ok := volatileColumns[column.Name]; ok {
+			continue
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// historySQL holds the statements recording a new version of a row.
+type historySQL struct {
+	// close ends the open version when the content hash differs.
+	close string
+	// open inserts a version unless one is still open.
+	open string
+}
+
+func newHistorySQL(table *schema.Table) historySQL {
+	history := table.Name + HistorySuffix
+	columns := historyColumns(table)
+	keys := table.PrimaryKeys()
+
+	// open: $1..$n are the content columns, then the hash and valid_from.
+	names := make([]string, len(columns))
+	values := make([]string, len(columns))
+	var openKeys []string
+	for i, column := range columns {
+		names[i] = column.Name
+		values[i] = fmt.Sprintf("$%d::%s", i+1, pgType(column))
+		if column.PrimaryKey {
+			openKeys = append(openKeys, fmt.Sprintf("%s = $%d::%s", column.Name, i+1, pgType(column)))
+		}
+	}
+	n := len(columns)
+	updates := make([]string, 0, len(columns)+2)
+	for _, column := range columns {
+		if !column.PrimaryKey {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", column.Name, column.Name))
+		}
+	}
+	// A second change within the same sync replaces the version it opened.
+	updates = append(updates, "content_hash = EXCLUDED.content_hash", "valid_to = NULL")
+	open := fmt.Sprintf(`INSERT INTO %s (%s, content_hash, valid_from)
+SELECT %s, $%d::TEXT, $%d::TIMESTAMPTZ
+WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s AND valid_to IS NULL)
+ON CONFLICT (%s, valid_from) DO UPDATE SET %s;`,
+		history, strings.Join(names, ", "), strings.Join(values, ", "), n+1, n+2,
+		history, strings.Join(openKeys, " AND "),
+		strings.Join(keys, ", "), strings.Join(updates, ",\n\t"))
+
+	// close: $1..$k are the primary key columns, then the hash and valid_to.
+	closeKeys := make([]string, len(keys))
+	for i, key := range keys {
+		closeKeys[i] = fmt.Sprintf("%s = $%d::%s", key, i+1, pgType(*table.Columns.Get(key)))
+	}
+	k := len(keys)
+	closeSQL := fmt.Sprintf(`UPDATE %s SET valid_to = $%d::TIMESTAMPTZ
+WHERE %s AND valid_to IS NULL AND content_hash <> $%d::TEXT;`,
+		history, k+2, strings.Join(closeKeys, " AND "), k+1)
+
+	return historySQL{close: closeSQL, open: open}
+}
+
+func (s *Store) historySQL(table *schema.Table) historySQL {
+	statements, ok := s.histories.Load(table.Name)
+	if !ok {
+		statements, _ = s.histories.LoadOrStore(table.Name, newHistorySQL(table))
+	}
+	return statements.(historySQL)
+}
+
+// queueHistory adds the statements that record resource as a new version
+// when its content changed since the open version.
+func (s *Store) queueHistory(batch *pgx.Batch, resource *schema.Resource) {
+	table := resource.Table
+	statements := s.historySQL(table)
+	hash := contentHash(resource)
+	at := versionTime(resource)
+
+	keys := table.PrimaryKeys()
+	closeArgs := make([]any, 0, len(keys)+2)
+	for _, key := range keys {
+		closeArgs = append(closeArgs, scalarArg(resource, key))
+	}
+	batch.Queue(statements.close, append(closeArgs, hash, at)...)
+
+	columns := historyColumns(table)
+	openArgs := make([]any, 0, len(columns)+2)
+	for _, column := range columns {
+		openArgs = append(openArgs, scalarArg(resource, column.Name))
+	}
+	batch.Queue(statements.open, append(openArgs, hash, at)...)
+}
+
+// contentHash fingerprints the content columns of resource.
+func contentHash(resource *schema.Resource) string {
+	h := sha256.New()
+	unhashed := unhashedColumns[resource.Table.Name]
+	for _, column := range historyColumns(resource.Table) {
+		if _, ok := unhashed[column.Name]; ok {
+			continue
+		}
+		h.Write([]byte(column.Name))
+		h.Write([]byte{0})
+		if value := resource.Get(column.Name); value != nil && value.IsValid() {
+			h.Write([]byte{1})
+			h.Write([]byte(value.String()))
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// versionTime is when a version became current: the sync that saw it, or now.
+func versionTime(resource *schema.Resource) time.Time {
+	if resource.Table.Columns.Get("synced_at") != nil {
+		if value := resource.Get("synced_at"); value != nil && value.IsValid() {
+			if at, ok := value.Get().(time.Time); ok {
+				return at
+			}
+		}
+	}
+	return time.Now()
+}
+
+func scalarArg(resource *schema.Resource, column string) any {
+	value := resource.Get(column)
+	if value == nil || !value.IsValid() {
+		return nil
+	}
+	return value.Get()
+}
+
+// closeRemovedHistory ends the open versions of objects of clusterUID that
+// are no longer live in table, after stale rows were deleted or tombstoned.
+func (s *Store) closeRemovedHistory(ctx context.Context, table string, clusterUID string, at time.Time) error {
+	_, err := s.exec(ctx, fmt.Sprintf(`
+UPDATE %[1]s%[2]s h SET valid_to = $2
+WHERE h.cluster_uid = $1 AND h.valid_to IS NULL
+AND NOT EXISTS (
+	SELECT 1 FROM %[1]s t
+	WHERE t.cluster_uid = h.cluster_uid AND t.uid = h.uid AND t.deleted_at IS NULL
+);
+`, table, HistorySuffix), clusterUID, at)
+	if err != nil {
+		return fmt.Errorf("close history %s: %w", table, err)
+	}
+	return nil
+}
+
+// closeObjectHistory ends the open version of a single removed object.
+func (s *Store) closeObjectHistory(ctx context.Context, table, clusterUID, uid string, at time.Time) error {
+	_, err := s.exec(ctx, fmt.Sprintf(`
+UPDATE %s%s SET valid_to = $3
+WHERE cluster_uid = $1 AND uid = $2 AND valid_to IS NULL;
+`, table, HistorySuffix), clusterUID, uid, at)
+	if err != nil {
+		return fmt.Errorf("close history %s %s: %w", table, uid, err)
+	}
+	return nil
+}
+
+// PruneHistory deletes the versions of table that stopped being current
+// before the given time. Open versions are always kept.
+func (s *Store) PruneHistory(ctx context.Context, table string, before time.Time) (int64, error) {
+	tag, err := s.exec(ctx, fmt.Sprintf(`
+DELETE FROM %s%s WHERE valid_to IS NOT NULL AND valid_to < $1;
+`, table, HistorySuffix), before)
+	if err != nil {
+		return 0, fmt.Errorf("prune history %s: %w", table, err)
+	}
+	return tag.RowsAffected(), nil
+}
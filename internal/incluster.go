@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+)
+
+// DefaultInClusterContext names the in-cluster connection in every table.
+const DefaultInClusterContext = "in-cluster"
+
+// serviceAccountNamespaceFile holds the namespace of the pod's service account.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// NewInCluster creates a client from the service account of the pod the
+// plugin runs in, named contextName in every table.
+func NewInCluster(ctx context.Context, contextName string, options ClientOptions) (*Client, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	options.apply(config)
+	client, err := newClient(config, contextName)
+	if err != nil {
+		return nil, err
+	}
+	client.InCluster = true
+	return client, nil
+}
+
+// RunningInCluster reports whether the plugin runs inside a pod with a
+// service account it can authenticate with.
+func RunningInCluster() bool {
+	_, err := rest.InClusterConfig()
+	return err == nil
+}
+
+// InClusterNamespace returns the namespace of the pod's service account, or
+// "default" when it cannot be read.
+func InClusterNamespace() string {
+	b, err := os.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return "default"
+	}
+	if namespace := strings.TrimSpace(string(b)); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Kubeconfig locates the kubeconfig files contexts are loaded from.
+type Kubeconfig struct {
+	// Paths are kubeconfig files, or directories whose files are all loaded.
+	// They are merged in order and the first definition of a context wins.
+	// Empty means KUBECONFIG, then ~/.kube/config, as kubectl does.
+	Paths []string
+}
+
+// loadingRules returns the client-go loading rules for k.
+func (k Kubeconfig) loadingRules() (*clientcmd.ClientConfigLoadingRules, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	paths := k.Paths
+	fromEnv := len(paths) == 0
+	if fromEnv {
+		// KUBECONFIG may list directories as well; without it the default
+		// rules fall back to ~/.kube/config.
+		paths = filepath.SplitList(os.Getenv(clientcmd.RecommendedConfigPathEnvVar))
+		if len(paths) == 0 {
+			return rules, nil
+		}
+	}
+
+	// Like kubectl, missing KUBECONFIG entries are skipped; paths set in the
+	// spec must exist.
+	files, err := expandKubeconfigPaths(paths, fromEnv)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no kubeconfig files found in %s", strings.Join(paths, ", "))
+	}
+	rules.Precedence = files
+	return rules, nil
+}
+
+// expandKubeconfigPaths replaces every directory in paths with the regular,
+// non-hidden files in it or linked from it, sorted by name. Paths that do
+// not exist are an error unless skipMissing is set.
+func expandKubeconfigPaths(paths []string, skipMissing bool) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		path = expandHome(path)
+		info, err := os.Stat(path)
+		if skipMissing && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("kubeconfig directory %s: %w", path, err)
+		}
+		// ReadDir returns names sorted. Entries are stat'ed so that symlinks
+		// to files count, as every file of a mounted Secret or ConfigMap is
+		// a symlink into its ..data directory.
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			file := filepath.Join(path, entry.Name())
+			info, err := os.Stat(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				// A dangling symlink.
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("kubeconfig %s: %w", file, err)
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestKubeconfigLoadingRules(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	teams := filepath.Join(dir, "teams.d")
+	missing := filepath.Join(dir, "missing")
+	for _, path := range []string{config, filepath.Join(teams, "b"), filepath.Join(teams, "a"), filepath.Join(teams, ".hidden")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A mounted Secret: visible files are symlinks into ..data, which is
+	// itself a symlink to a hidden timestamped directory.
+	secret := filepath.Join(dir, "secret")
+	if err := os.MkdirAll(filepath.Join(secret, "..2026_01_01"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(secret, "..2026_01_01", "team-a"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"..data":   "..2026_01_01",
+		"team-a":   filepath.Join("..data", "team-a"),
+		"dangling": filepath.Join("..data", "gone"),
+		"subdir":   "..2026_01_01",
+	} {
+		if err := os.Symlink(target, filepath.Join(secret, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		env     []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "spec paths expand directories",
+			paths: []string{config, teams},
+			want:  []string{config, filepath.Join(teams, "a"), filepath.Join(teams, "b")},
+		},
+		{
+			name:  "symlinked files in a directory are kept",
+			paths: []string{secret},
+			want:  []string{filepath.Join(secret, "team-a")},
+		},
+		{
+			name:    "missing spec path fails",
+			paths:   []string{config, missing},
+			wantErr: true,
+		},
+		{
+			name: "missing KUBECONFIG entry is skipped",
+			env:  []string{missing, config},
+			want: []string{config},
+		},
+		{
+			name:    "KUBECONFIG without any file fails",
+			env:     []string{missing},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(clientcmd.RecommendedConfigPathEnvVar, strings.Join(tt.env, string(os.PathListSeparator)))
+			rules, err := Kubeconfig{Paths: tt.paths}.loadingRules()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loadingRules() = %v, want an error", rules.Precedence)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(rules.Precedence, tt.want) {
+				t.Errorf("Precedence = %v, want %v", rules.Precedence, tt.want)
+			}
+		})
+	}
+}
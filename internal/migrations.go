@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationLockID is the advisory lock key held while migrating, so plugins
+// starting at the same time apply each migration once.
+const migrationLockID int64 = 0x6b38732d637573 // "k8s-cus"
+
+const schemaMigrationsSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+`
+
+// Migration is one versioned step of the store schema. Statements must be
+// idempotent, so databases created by older releases without
+// schema_migrations can be brought forward safely.
+type Migration struct {
+	Version int64
+	Name    string
+	SQL     string
+}
+
+// Migrations lists every schema change in order. Never edit an applied
+// migration; append a new one instead.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		SQL: `
+CREATE TABLE IF NOT EXISTS k8s_clusters (
+	cluster_uid TEXT PRIMARY KEY,
+	context_name TEXT,
+	cluster_name TEXT NOT NULL,
+	server TEXT,
+	ca_file TEXT,
+	insecure_skip_verify BOOLEAN DEFAULT FALSE,
+	namespace TEXT DEFAULT 'default',
+	kubernetes_version TEXT,
+	node_count INTEGER,
+	synced_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS k8s_namespaces (
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	uid TEXT NOT NULL,
+	name TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (cluster_uid, uid),
+	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS k8s_pods (
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	uid TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (cluster_uid, uid),
+	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS k8s_deployments (
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	uid TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	replicas INTEGER NOT NULL,
+	ready INTEGER NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (cluster_uid, uid),
+	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS k8s_services (
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	uid TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	cluster_ip TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (cluster_uid, uid),
+	FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
+);
+
+DO $$
+BEGIN
+	IF to_regclass('k8s_custom_resources') IS NULL THEN
+		CREATE TABLE IF NOT EXISTS k8s_crds (
+			cluster_uid TEXT NOT NULL,
+			context_name TEXT,
+			uid TEXT NOT NULL,
+			name TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			plural TEXT NOT NULL,
+			scope TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (cluster_uid, uid),
+			FOREIGN KEY (cluster_uid) REFERENCES k8s_clusters(cluster_uid) ON DELETE CASCADE
+		);
+	END IF;
+END $$;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "rename_k8s_crds",
+		SQL: `
+DO $$
+BEGIN
+	IF to_regclass('k8s_crds') IS NOT NULL AND to_regclass('k8s_custom_resources') IS NULL THEN
+		ALTER TABLE k8s_crds RENAME TO k8s_custom_resources;
+	END IF;
+END $$;
+`,
+	},
+	{
+		// Tables resolve concurrently, so children can be written before
+		// their cluster row.
+		Version: 3,
+		Name:    "drop_cluster_foreign_keys",
+		SQL: `
+ALTER TABLE k8s_namespaces DROP CONSTRAINT IF EXISTS k8s_namespaces_cluster_uid_fkey;
+ALTER TABLE k8s_pods DROP CONSTRAINT IF EXISTS k8s_pods_cluster_uid_fkey;
+ALTER TABLE k8s_deployments DROP CONSTRAINT IF EXISTS k8s_deployments_cluster_uid_fkey;
+ALTER TABLE k8s_services DROP CONSTRAINT IF EXISTS k8s_services_cluster_uid_fkey;
+ALTER TABLE k8s_custom_resources DROP CONSTRAINT IF EXISTS k8s_crds_cluster_uid_fkey;
+ALTER TABLE k8s_custom_resources DROP CONSTRAINT IF EXISTS k8s_custom_resources_cluster_uid_fkey;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "uid_to_uuid",
+		SQL: `
+DO $$
+DECLARE
+	t TEXT;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['k8s_namespaces', 'k8s_pods', 'k8s_deployments', 'k8s_services', 'k8s_custom_resources'] LOOP
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = t AND column_name = 'uid' AND data_type = 'text'
+		) THEN
+			EXECUTE format('ALTER TABLE %I ALTER COLUMN uid TYPE UUID USING uid::uuid', t);
+		END IF;
+	END LOOP;
+END $$;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "widen_counts_to_bigint",
+		SQL: `
+ALTER TABLE k8s_clusters ALTER COLUMN node_count TYPE BIGINT;
+ALTER TABLE k8s_deployments ALTER COLUMN replicas TYPE BIGINT;
+ALTER TABLE k8s_deployments ALTER COLUMN ready TYPE BIGINT;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "add_sync_columns",
+		SQL: `
+DO $$
+DECLARE
+	t TEXT;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['k8s_namespaces', 'k8s_pods', 'k8s_deployments', 'k8s_services', 'k8s_custom_resources'] LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS sync_id UUID', t);
+		EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS synced_at TIMESTAMPTZ', t);
+		EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ', t);
+	END LOOP;
+END $$;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "create_k8s_resource_versions",
+		SQL:     resourceVersionsSQL,
+	},
+	{
+		Version: 8,
+		Name:    "add_cluster_tags",
+		SQL: `
+ALTER TABLE k8s_clusters ADD COLUMN IF NOT EXISTS tags JSONB;
+ALTER TABLE IF EXISTS k8s_clusters_history ADD COLUMN IF NOT EXISTS tags JSONB;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "add_cluster_sync_filters",
+		SQL: `
+ALTER TABLE k8s_clusters ADD COLUMN IF NOT EXISTS sync_filters JSONB;
+ALTER TABLE IF EXISTS k8s_clusters_history ADD COLUMN IF NOT EXISTS sync_filters JSONB;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create_k8s_sync_errors",
+		SQL:     syncErrorsSQL,
+	},
+	{
+		Version: 11,
+		Name:    "create_k8s_sync_runs",
+		SQL:     syncRunsSQL,
+	},
+	{
+		Version: 12,
+		Name:    "create_k8s_cluster_aliases",
+		SQL:     clusterAliasesSQL,
+	},
+	{
+		Version: 13,
+		Name:    "create_k8s_nodes",
+		SQL: `
+CREATE TABLE IF NOT EXISTS k8s_nodes (
+	cluster_uid TEXT NOT NULL,
+	context_name TEXT,
+	uid UUID NOT NULL,
+	name TEXT NOT NULL,
+	roles JSONB,
+	provider_id TEXT,
+	addresses JSONB,
+	capacity_cpu_millicores BIGINT,
+	capacity_memory_bytes BIGINT,
+	capacity_pods BIGINT,
+	capacity_ephemeral_storage_bytes BIGINT,
+	allocatable_cpu_millicores BIGINT,
+	allocatable_memory_bytes BIGINT,
+	allocatable_pods BIGINT,
+	allocatable_ephemeral_storage_bytes BIGINT,
+	conditions JSONB,
+	taints JSONB,
+	unschedulable BOOLEAN NOT NULL,
+	kubelet_version TEXT,
+	os_image TEXT,
+	operating_system TEXT,
+	kernel_version TEXT,
+	container_runtime_version TEXT,
+	architecture TEXT,
+	labels JSONB,
+	created_at TIMESTAMPTZ NOT NULL,
+	sync_id UUID,
+	synced_at TIMESTAMPTZ,
+	deleted_at TIMESTAMPTZ,
+	PRIMARY KEY (cluster_uid, uid)
+);
+`,
+	},
+	{
+		Version: 14,
+		Name:    "add_sync_run_resource_filters",
+		SQL: `
+ALTER TABLE k8s_sync_run_resources ADD COLUMN IF NOT EXISTS label_selector TEXT;
+ALTER TABLE k8s_sync_run_resources ADD COLUMN IF NOT EXISTS field_selector TEXT;
+ALTER TABLE k8s_sync_run_resources ADD COLUMN IF NOT EXISTS namespace_filter JSONB;
+`,
+	},
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrate applies the pending migrations in order, each in its own
+// transaction and recorded in schema_migrations. It holds an advisory lock
+// for the duration, so concurrent starts wait for each other.
+func (s *Store) Migrate(ctx context.Context) ([]Migration, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return nil, fmt.Errorf("lock migrations: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}()
+
+	if _, err := conn.Exec(ctx, schemaMigrationsSQL); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, migration := range Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, migration.SQL); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// MigrationStatuses reports every known migration and whether it was applied.
+func (s *Store) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, schemaMigrationsSQL); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(Migrations))
+	for i, migration := range Migrations {
+		statuses[i].Migration = migration
+		if at, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+func appliedMigrations(ctx context.Context, conn *pgx.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var (
+			version   int64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
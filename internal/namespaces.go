@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// maxNamespaceLists is the number of allowed namespaces up to which
+// namespaced resources are listed one namespace at a time instead of with a
+// single cluster-wide list.
+const maxNamespaceLists = 20
+
+// NamespaceFilter limits namespaced resources to some namespaces. Include
+// and Exclude hold exact names or globs ("team-*"); LabelSelector selects
+// namespaces by their labels, e.g. "env=prod".
+type NamespaceFilter struct {
+	Include       []string `json:"include,omitempty"`
+	Exclude       []string `json:"exclude,omitempty"`
+	LabelSelector string   `json:"label_selector,omitempty"`
+}
+
+// IsZero reports whether the filter allows every namespace.
+func (f NamespaceFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.LabelSelector == ""
+}
+
+// Validate checks the globs and the label selector.
+func (f NamespaceFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+	if _, err := labels.Parse(f.LabelSelector); err != nil {
+		return fmt.Errorf("invalid namespace label_selector: %w", err)
+	}
+	return nil
+}
+
+// allowsName reports whether name passes Include and Exclude.
+func (f NamespaceFilter) allowsName(name string) bool {
+	if matchesGlob(f.Exclude, name) {
+		return false
+	}
+	return len(f.Include) == 0 || matchesGlob(f.Include, name)
+}
+
+// exactNames returns the namespaces to list when Include only holds exact
+// names and no selector is set, so they are known without listing
+// namespaces. That keeps namespace-scoped credentials working.
+func (f NamespaceFilter) exactNames() ([]string, bool) {
+	if len(f.Include) == 0 || f.LabelSelector != "" {
+		return nil, false
+	}
+	names := []string{}
+	for _, name := range f.Include {
+		if strings.ContainsAny(name, "*?[") {
+			return nil, false
+		}
+		if f.allowsName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func matchesGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NamespaceScope is the resolved NamespaceFilter of one context.
+type NamespaceScope struct {
+	filter   NamespaceFilter
+	selector labels.Selector
+	// Namespaces are listed one at a time when non-nil, even if empty;
+	// otherwise namespaced resources are listed cluster-wide and filtered
+	// with Allows.
+	Namespaces []string
+	// selected holds the namespaces matching the label selector.
+	selected map[string]struct{}
+}
+
+// Allows reports whether objects in namespace are synced.
+func (s *NamespaceScope) Allows(namespace string) bool {
+	if s == nil {
+		return true
+	}
+	if !s.filter.allowsName(namespace) {
+		return false
+	}
+	if s.selected != nil {
+		_, ok := s.selected[namespace]
+		return ok
+	}
+	return true
+}
+
+// AllowsNamespace reports whether the namespace object itself is synced.
+func (s *NamespaceScope) AllowsNamespace(namespace *corev1.Namespace) bool {
+	if s == nil {
+		return true
+	}
+	return s.filter.allowsName(namespace.Name) && s.selector.Matches(labels.Set(namespace.Labels))
+}
+
+// namespaceScope resolves a filter once per client.
+type namespaceScope struct {
+	once  sync.Once
+	scope *NamespaceScope
+	err   error
+}
+
+// NamespaceScope resolves the client's NamespaceFilter, listing namespaces
+// only when globs or a label selector need it. It returns nil when every
+// namespace is allowed.
+func (c *Client) NamespaceScope(ctx context.Context) (*NamespaceScope, error) {
+	if c.Namespaces.IsZero() {
+		return nil, nil
+	}
+	c.namespaces.once.Do(func() {
+		c.namespaces.scope, c.namespaces.err = c.resolveNamespaces(ctx)
+	})
+	return c.namespaces.scope, c.namespaces.err
+}
+
+func (c *Client) resolveNamespaces(ctx context.Context) (*NamespaceScope, error) {
+	filter := c.Namespaces
+	selector, err := labels.Parse(filter.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	scope := &NamespaceScope{filter: filter, selector: selector}
+
+	if names, ok := filter.exactNames(); ok {
+		scope.Namespaces = names
+		return scope, nil
+	}
+	if len(filter.Include) == 0 && filter.LabelSelector == "" {
+		// Only excludes: one cluster-wide list, filtered by name.
+		return scope, nil
+	}
+
+	names := []string{}
+	opts := metav1.ListOptions{LabelSelector: filter.LabelSelector}
+	err = ListPages(ctx, opts, c.PageSize, c.Clientset.CoreV1().Namespaces().List, func(page *corev1.NamespaceList) error {
+		for _, namespace := range page.Items {
+			if filter.allowsName(namespace.Name) {
+				names = append(names, namespace.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	sort.Strings(names)
+
+	if filter.LabelSelector != "" {
+		scope.selected = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			scope.selected[name] = struct{}{}
+		}
+	}
+	if len(names) <= maxNamespaceLists {
+		scope.Namespaces = names
+	}
+	return scope, nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceFilterExactNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter NamespaceFilter
+		want   []string
+		ok     bool
+	}{
+		{
+			name:   "no include lists every namespace",
+			filter: NamespaceFilter{},
+			ok:     false,
+		},
+		{
+			name:   "only excludes lists every namespace",
+			filter: NamespaceFilter{Exclude: []string{"kube-system"}},
+			ok:     false,
+		},
+		{
+			name:   "exact names are sorted",
+			filter: NamespaceFilter{Include: []string{"payments", "default", "billing"}},
+			want:   []string{"billing", "default", "payments"},
+			ok:     true,
+		},
+		{
+			name:   "excluded names are dropped",
+			filter: NamespaceFilter{Include: []string{"payments", "kube-system"}, Exclude: []string{"kube-*"}},
+			want:   []string{"payments"},
+			ok:     true,
+		},
+		{
+			name:   "every name excluded",
+			filter: NamespaceFilter{Include: []string{"kube-system"}, Exclude: []string{"kube-system"}},
+			want:   []string{},
+			ok:     true,
+		},
+		{
+			name:   "a star glob needs a namespace list",
+			filter: NamespaceFilter{Include: []string{"payments", "team-*"}},
+			ok:     false,
+		},
+		{
+			name:   "a question mark glob needs a namespace list",
+			filter: NamespaceFilter{Include: []string{"team-?"}},
+			ok:     false,
+		},
+		{
+			name:   "a character class needs a namespace list",
+			filter: NamespaceFilter{Include: []string{"team-[ab]"}},
+			ok:     false,
+		},
+		{
+			name:   "a label selector needs a namespace list",
+			filter: NamespaceFilter{Include: []string{"payments"}, LabelSelector: "team=payments"},
+			ok:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.filter.exactNames()
+			if ok != tt.ok {
+				t.Fatalf("exactNames() ok = %v, want %v", ok, tt.ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("exactNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// DefaultPageSize is the number of objects requested per list call.
+const DefaultPageSize int64 = 500
+
+// maxListRestarts bounds how often an expired list is restarted before giving up.
+const maxListRestarts = 3
+
+// ListFunc is a typed client-go List method, e.g. Pods("").List.
+type ListFunc[L runtime.Object] func(ctx context.Context, opts metav1.ListOptions) (L, error)
+
+// WatchFunc is a client-go Watch method, e.g. Pods("").Watch.
+type WatchFunc func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+
+// ListPages pages through list with Limit/Continue and hands every page to fn
+// as it arrives, so only one page is held in memory at a time.
+//
+// If a continue token expires (410 Gone) the list restarts from a fresh
+// snapshot. Objects already handed to fn are filtered out of the restarted
+// pages by UID, so each object is seen at most once.
+func ListPages[L runtime.Object](ctx context.Context, opts metav1.ListOptions, pageSize int64, list ListFunc[L], fn func(page L) error) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	opts.Limit = pageSize
+	opts.Continue = ""
+
+	seen := map[types.UID]struct{}{}
+	restarts := 0
+	for {
+		page, err := list(ctx, opts)
+		if err != nil {
+			if opts.Continue != "" && (apierrors.IsResourceExpired(err) || apierrors.IsGone(err)) && restarts < maxListRestarts {
+				restarts++
+				opts.Continue = ""
+				continue
+			}
+			return err
+		}
+
+		if err := dedupePage(page, seen, restarts > 0); err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		listMeta, err := meta.ListAccessor(page)
+		if err != nil {
+			return fmt.Errorf("read list metadata: %w", err)
+		}
+		opts.Continue = listMeta.GetContinue()
+		if opts.Continue == "" {
+			return nil
+		}
+	}
+}
+
+// dedupePage records the UIDs in page and, after a restart, drops the
+// objects that an earlier page already returned.
+func dedupePage(page runtime.Object, seen map[types.UID]struct{}, filter bool) error {
+	items, err := meta.ExtractList(page)
+	if err != nil {
+		return fmt.Errorf("extract list items: %w", err)
+	}
+
+	kept := items[:0]
+	for _, item := range items {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+		uid := accessor.GetUID()
+		if _, ok := seen[uid]; ok && filter {
+			continue
+		}
+		seen[uid] = struct{}{}
+		kept = append(kept, item)
+	}
+	if !filter || len(kept) == len(items) {
+		return nil
+	}
+	return meta.SetList(page, kept)
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// listCall is one scripted response of fakeList.
+type listCall struct {
+	// continueToken is the token the call must be made with.
+	continueToken string
+	pods          []string
+	next          string
+	err           error
+}
+
+// fakeList answers list calls from calls in order and fails the test when
+// ListPages asks for something else.
+func fakeList(t *testing.T, calls []listCall) ListFunc[*corev1.PodList] {
+	t.Helper()
+	made := 0
+	t.Cleanup(func() {
+		if made != len(calls) {
+			t.Errorf("made %d list calls, want %d", made, len(calls))
+		}
+	})
+	return func(_ context.Context, opts metav1.ListOptions) (*corev1.PodList, error) {
+		if made == len(calls) {
+			t.Fatalf("unexpected list call with continue %q", opts.Continue)
+		}
+		call := calls[made]
+		made++
+		if opts.Continue != call.continueToken {
+			t.Fatalf("list call %d: continue = %q, want %q", made, opts.Continue, call.continueToken)
+		}
+		if opts.Limit != 2 {
+			t.Fatalf("list call %d: limit = %d, want 2", made, opts.Limit)
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		return podList(call.next, call.pods...), nil
+	}
+}
+
+func podList(next string, uids ...string) *corev1.PodList {
+	list := &corev1.PodList{ListMeta: metav1.ListMeta{Continue: next}}
+	for _, uid := range uids {
+		list.Items = append(list.Items, corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)}})
+	}
+	return list
+}
+
+func podUIDs(list *corev1.PodList) []string {
+	uids := []string{}
+	for _, pod := range list.Items {
+		uids = append(uids, string(pod.UID))
+	}
+	return uids
+}
+
+func TestListPages(t *testing.T) {
+	expired := apierrors.NewResourceExpired("continue token expired")
+	gone := apierrors.NewGone("continue token expired")
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+
+	tests := []struct {
+		name    string
+		calls   []listCall
+		want    [][]string
+		wantErr error
+	}{
+		{
+			name:  "single page",
+			calls: []listCall{{pods: []string{"a", "b"}}},
+			want:  [][]string{{"a", "b"}},
+		},
+		{
+			name:  "empty list",
+			calls: []listCall{{}},
+			want:  [][]string{{}},
+		},
+		{
+			name: "pages follow the continue token",
+			calls: []listCall{
+				{pods: []string{"a", "b"}, next: "1"},
+				{continueToken: "1", pods: []string{"c", "d"}, next: "2"},
+				{continueToken: "2", pods: []string{"e"}},
+			},
+			want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}},
+		},
+		{
+			name: "an expired token restarts without repeating objects",
+			calls: []listCall{
+				{pods: []string{"a", "b"}, next: "1"},
+				{continueToken: "1", err: expired},
+				{pods: []string{"b", "c"}, next: "2"},
+				{continueToken: "2", pods: []string{"a", "d"}},
+			},
+			want: [][]string{{"a", "b"}, {"c"}, {"d"}},
+		},
+		{
+			name: "410 Gone restarts too",
+			calls: []listCall{
+				{pods: []string{"a", "b"}, next: "1"},
+				{continueToken: "1", err: gone},
+				{pods: []string{"a", "b"}},
+			},
+			want: [][]string{{"a", "b"}, {}},
+		},
+		{
+			name: "restarts are bounded",
+			calls: []listCall{
+				{pods: []string{"a"}, next: "1"},
+				{continueToken: "1", err: expired},
+				{next: "2"},
+				{continueToken: "2", err: expired},
+				{next: "3"},
+				{continueToken: "3", err: expired},
+				{next: "4"},
+				{continueToken: "4", err: expired},
+			},
+			want:    [][]string{{"a"}, {}, {}, {}},
+			wantErr: expired,
+		},
+		{
+			name:    "an expired first page is not restarted",
+			calls:   []listCall{{err: expired}},
+			wantErr: expired,
+		},
+		{
+			name: "other errors are returned",
+			calls: []listCall{
+				{pods: []string{"a"}, next: "1"},
+				{continueToken: "1", err: forbidden},
+			},
+			want:    [][]string{{"a"}},
+			wantErr: forbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]string
+			err := ListPages(context.Background(), metav1.ListOptions{Continue: "stale"}, 2, fakeList(t, tt.calls), func(page *corev1.PodList) error {
+				pages = append(pages, podUIDs(page))
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListPages() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(pages, tt.want) {
+				t.Errorf("pages = %v, want %v", pages, tt.want)
+			}
+		})
+	}
+
+	t.Run("an error from fn stops paging", func(t *testing.T) {
+		stop := errors.New("stop")
+		list := fakeList(t, []listCall{{pods: []string{"a", "b"}, next: "1"}})
+		err := ListPages(context.Background(), metav1.ListOptions{}, 2, list, func(*corev1.PodList) error {
+			return stop
+		})
+		if !errors.Is(err, stop) {
+			t.Errorf("ListPages() error = %v, want %v", err, stop)
+		}
+	})
+
+	t.Run("the page size defaults", func(t *testing.T) {
+		var limit int64
+		list := func(_ context.Context, opts metav1.ListOptions) (*corev1.PodList, error) {
+			limit = opts.Limit
+			return podList(""), nil
+		}
+		if err := ListPages(context.Background(), metav1.ListOptions{}, 0, list, func(*corev1.PodList) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if limit != DefaultPageSize {
+			t.Errorf("limit = %d, want %d", limit, DefaultPageSize)
+		}
+	})
+}
+
+func TestDedupePage(t *testing.T) {
+	tests := []struct {
+		name     string
+		seen     []string
+		page     []string
+		filter   bool
+		want     []string
+		wantSeen []string
+	}{
+		{
+			name:     "records new objects",
+			page:     []string{"a", "b"},
+			want:     []string{"a", "b"},
+			wantSeen: []string{"a", "b"},
+		},
+		{
+			name:     "keeps seen objects without filtering",
+			seen:     []string{"a"},
+			page:     []string{"a", "b"},
+			want:     []string{"a", "b"},
+			wantSeen: []string{"a", "b"},
+		},
+		{
+			name:     "drops seen objects when filtering",
+			seen:     []string{"a", "c"},
+			page:     []string{"a", "b", "c", "d"},
+			filter:   true,
+			want:     []string{"b", "d"},
+			wantSeen: []string{"a", "b", "c", "d"},
+		},
+		{
+			name:     "can drop every object",
+			seen:     []string{"a", "b"},
+			page:     []string{"b", "a"},
+			filter:   true,
+			want:     []string{},
+			wantSeen: []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[types.UID]struct{}{}
+			for _, uid := range tt.seen {
+				seen[types.UID(uid)] = struct{}{}
+			}
+			page := podList("", tt.page...)
+			if err := dedupePage(page, seen, tt.filter); err != nil {
+				t.Fatal(err)
+			}
+			if got := podUIDs(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("page = %v, want %v", got, tt.want)
+			}
+			wantSeen := map[types.UID]struct{}{}
+			for _, uid := range tt.wantSeen {
+				wantSeen[types.UID(uid)] = struct{}{}
+			}
+			if !reflect.DeepEqual(seen, wantSeen) {
+				t.Errorf("seen = %v, want %v", seen, wantSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/types"
+)
+
+// pgType maps an Arrow column type to the Postgres type used by the Store.
+func pgType(column schema.Column) string {
+	switch {
+	case arrow.TypeEqual(column.Type, types.ExtensionTypes.UUID):
+		return "UUID"
+	case arrow.TypeEqual(column.Type, types.ExtensionTypes.JSON):
+		return "JSONB"
+	}
+
+	switch column.Type.ID() {
+	case arrow.BOOL:
+		return "BOOLEAN"
+	case arrow.INT8, arrow.INT16, arrow.UINT8:
+		return "SMALLINT"
+	case arrow.INT32, arrow.UINT16:
+		return "INTEGER"
+	case arrow.INT64, arrow.UINT32, arrow.UINT64:
+		return "BIGINT"
+	case arrow.FLOAT32:
+		return "REAL"
+	case arrow.FLOAT64:
+		return "DOUBLE PRECISION"
+	case arrow.TIMESTAMP:
+		return "TIMESTAMPTZ"
+	case arrow.LIST:
+		return pgType(schema.Column{Type: column.Type.(*arrow.ListType).Elem()}) + "[]"
+	default:
+		return "TEXT"
+	}
+}
+
+// pgTypeName is pgType as Postgres' format_type reports it.
+func pgTypeName(column schema.Column) string {
+	name := strings.ToLower(pgType(column))
+	return strings.ReplaceAll(name, "timestamptz", "timestamp with time zone")
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how often a failed request is retried.
+	DefaultMaxRetries = 5
+	// DefaultRetryBackoff is the backoff before the first retry; it doubles
+	// with every further retry.
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// DefaultRetryMaxBackoff caps the backoff between retries.
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// Retry describes a request that is about to be retried.
+type Retry struct {
+	Method string
+	Path   string
+	// Attempt counts the retries of this request, starting at 1.
+	Attempt int
+	// StatusCode is the response status, or 0 when the request failed.
+	StatusCode int
+	Err        error
+	// Wait is the delay before the retry, from Retry-After when the server
+	// sent one.
+	Wait time.Duration
+}
+
+// RetryPolicy retries reads the API server throttled (429) or failed with a
+// 5xx status or a connection error, with exponential backoff and full
+// jitter. Retry-After is honored when the server sends one, up to
+// MaxBackoff.
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// OnRetry is called before every retry, e.g. to log it.
+	OnRetry func(Retry)
+}
+
+// retryTransport applies a RetryPolicy and a timeout per attempt to the
+// requests of a client. It is the only retry layer: client-go retries
+// responses carrying Retry-After on its own, so the header is removed from
+// the responses it hands back.
+type retryTransport struct {
+	next    http.RoundTripper
+	policy  RetryPolicy
+	timeout time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Only requests that can be sent again unchanged are retried.
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	for attempt := 1; ; attempt++ {
+		resp, err := t.send(req)
+		if !idempotent || attempt > t.policy.MaxRetries || !retryable(req, resp, err) {
+			if resp != nil {
+				resp.Header.Del("Retry-After")
+			}
+			return resp, err
+		}
+
+		retry := Retry{
+			Method:  req.Method,
+			Path:    req.URL.Path,
+			Attempt: attempt,
+			Err:     err,
+			Wait:    t.policy.backoff(attempt),
+		}
+		if resp != nil {
+			retry.StatusCode = resp.StatusCode
+			if wait, ok := retryAfter(resp); ok {
+				retry.Wait = t.policy.capWait(wait)
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			_ = resp.Body.Close()
+		}
+		if t.policy.OnRetry != nil {
+			t.policy.OnRetry(retry)
+		}
+
+		timer := time.NewTimer(retry.Wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send makes a single attempt under the request timeout. Watches are
+// long-running by design; their duration is bounded by TimeoutSeconds.
+func (t *retryTransport) send(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 || req.URL.Query().Get("watch") == "true" {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// Connection errors and attempts that hit the request timeout are
+		// retried, unless the caller gave up.
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random delay of up to Backoff * 2^(attempt-1), capped at
+// MaxBackoff.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.Backoff
+	for i := 1; i < attempt && limit < p.MaxBackoff; i++ {
+		limit *= 2
+	}
+	if limit > p.MaxBackoff {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit) + 1
+}
+
+// capWait bounds a delay the server asked for by MaxBackoff.
+func (p RetryPolicy) capWait(wait time.Duration) time.Duration {
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return wait
+}
+
+// retryAfter parses the Retry-After header, in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// cancelBody releases a request's timeout once its body has been read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
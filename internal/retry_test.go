@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		attempt int
+		limit   time.Duration
+	}{
+		{attempt: 1, limit: 100 * time.Millisecond},
+		{attempt: 2, limit: 200 * time.Millisecond},
+		{attempt: 3, limit: 400 * time.Millisecond},
+		{attempt: 4, limit: 800 * time.Millisecond},
+		{attempt: 5, limit: time.Second},
+		{attempt: 50, limit: time.Second},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			wait := policy.backoff(tt.attempt)
+			if wait <= 0 || wait > tt.limit {
+				t.Fatalf("backoff(%d) = %s, want in (0, %s]", tt.attempt, wait, tt.limit)
+			}
+		}
+	}
+
+	if wait := (RetryPolicy{}).backoff(1); wait != 0 {
+		t.Errorf("backoff without a base = %s, want 0", wait)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{name: "missing", header: "", ok: false},
+		{name: "seconds", header: "3", want: 3 * time.Second, ok: true},
+		{name: "zero", header: "0", want: 0, ok: true},
+		{name: "negative", header: "-1", ok: false},
+		{name: "garbage", header: "soon", ok: false},
+		{name: "past date", header: "Mon, 02 Jan 2006 15:04:05 GMT", want: 0, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			got, ok := retryAfter(resp)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	t.Run("future date", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		got, ok := retryAfter(resp)
+		if !ok || got <= 0 || got > time.Minute {
+			t.Errorf("retryAfter = %s, %v, want up to a minute", got, ok)
+		}
+	})
+}
+
+func TestRetryable(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   bool
+	}{
+		{name: "ok", status: http.StatusOK, want: false},
+		{name: "not found", status: http.StatusNotFound, want: false},
+		{name: "forbidden", status: http.StatusForbidden, want: false},
+		{name: "throttled", status: http.StatusTooManyRequests, want: true},
+		{name: "internal error", status: http.StatusInternalServerError, want: true},
+		{name: "bad gateway", status: http.StatusBadGateway, want: true},
+		{name: "unavailable", status: http.StatusServiceUnavailable, want: true},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, want: true},
+		{name: "not implemented", status: http.StatusNotImplemented, want: false},
+		{name: "connection error", err: errors.New("connection reset"), want: true},
+		{name: "attempt timeout", err: context.DeadlineExceeded, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "caller gave up", ctx: cancelled, err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/api", nil)
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+			if got := retryable(req, resp, tt.err); got != tt.want {
+				t.Errorf("retryable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRetryTransportGivesUp(t *testing.T) {
+	attempts := 0
+	var retries []Retry
+	transport := &retryTransport{
+		next: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			attempts++
+			resp := &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("slow down")),
+			}
+			resp.Header.Set("Retry-After", "3600")
+			return resp, nil
+		}),
+		policy: RetryPolicy{
+			MaxRetries: 2,
+			Backoff:    time.Millisecond,
+			MaxBackoff: time.Millisecond,
+			OnRetry:    func(retry Retry) { retries = append(retries, retry) },
+		},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/api/v1/pods", nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 || len(retries) != 2 {
+		t.Errorf("got %d attempts and %d retries, want 3 and 2", attempts, len(retries))
+	}
+	for _, retry := range retries {
+		if retry.Wait != time.Millisecond {
+			t.Errorf("Retry-After wait = %s, want it capped at %s", retry.Wait, time.Millisecond)
+		}
+	}
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		t.Errorf("final response keeps Retry-After %q, so client-go would retry it again", value)
+	}
+}
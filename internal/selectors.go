@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Selector narrows the list and watch calls of one resource, e.g.
+// FieldSelector "status.phase!=Succeeded" for pods.
+type Selector struct {
+	LabelSelector string `json:"label_selector,omitempty"`
+	FieldSelector string `json:"field_selector,omitempty"`
+}
+
+// IsZero reports whether the selector matches every object.
+func (s Selector) IsZero() bool {
+	return s.LabelSelector == "" && s.FieldSelector == ""
+}
+
+// Validate parses both selectors.
+func (s Selector) Validate() error {
+	if _, err := labels.Parse(s.LabelSelector); err != nil {
+		return fmt.Errorf("invalid label_selector: %w", err)
+	}
+	if _, err := fields.ParseSelector(s.FieldSelector); err != nil {
+		return fmt.Errorf("invalid field_selector: %w", err)
+	}
+	return nil
+}
+
+// ListOptions returns the options every list and watch of resource starts from.
+func (c *Client) ListOptions(resource string) metav1.ListOptions {
+	selector := c.Selectors[resource]
+	return metav1.ListOptions{
+		LabelSelector: selector.LabelSelector,
+		FieldSelector: selector.FieldSelector,
+	}
+}
+
+// SelectorKey is the bookmark key of key when listed with opts. Each
+// selector keeps its own bookmark, so changing it starts with a full list.
+func SelectorKey(key string, opts metav1.ListOptions) string {
+	if opts.LabelSelector == "" && opts.FieldSelector == "" {
+		return key
+	}
+	return key + "#" + opts.LabelSelector + "#" + opts.FieldSelector
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// querier is the subset of pgxpool.Pool and pgx.Tx the Store uses.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// Begin starts a snapshot: a Store whose writes all happen in one
+// transaction and only become visible to readers on Commit. Rollback leaves
+// the previous data untouched.
+func (s *Store) Begin(ctx context.Context) (*Store, error) {
+	if s.tx != nil {
+		return nil, errors.New("snapshot already started")
+	}
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Tombstones: s.Tombstones,
+		History:    s.History,
+		tx:         tx,
+		txMu:       &sync.Mutex{},
+		upserts:    s.upserts,
+		histories:  s.histories,
+	}, nil
+}
+
+// Commit makes the writes of a snapshot visible.
+func (s *Store) Commit(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return s.tx.Commit(ctx)
+}
+
+// Rollback discards the writes of a snapshot. It is a no-op after Commit.
+func (s *Store) Rollback(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	return s.tx.Rollback(ctx)
+}
+
+// run calls fn with the pool, or inside a snapshot with a savepoint of its
+// transaction, so a failed statement does not abort the whole snapshot. The
+// transaction's connection is used by one caller at a time.
+func (s *Store) run(ctx context.Context, fn func(db querier) error) error {
+	if s.tx == nil {
+		return fn(s.pool)
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	savepoint, err := s.tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(savepoint); err != nil {
+		_ = savepoint.Rollback(ctx)
+		return err
+	}
+	return savepoint.Commit(ctx)
+}
+
+// exec runs a single statement through run.
+func (s *Store) exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	var tag pgconn.CommandTag
+	err := s.run(ctx, func(db querier) error {
+		var err error
+		tag, err = db.Exec(ctx, sql, args...)
+		return err
+	})
+	return tag, err
+}
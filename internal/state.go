@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// resourceVersionsSQL creates the table holding the last resourceVersion
+// applied per cluster and resource, used to resume without a full relist.
+const resourceVersionsSQL = `
+CREATE TABLE IF NOT EXISTS k8s_resource_versions (
+	cluster_uid TEXT NOT NULL,
+	resource TEXT NOT NULL,
+	resource_version TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (cluster_uid, resource)
+);
+`
+
+// ResourceVersion returns the saved resourceVersion for a cluster and
+// resource, or "" when none was saved yet.
+func (s *Store) ResourceVersion(ctx context.Context, clusterUID, resource string) (string, error) {
+	var resourceVersion string
+	err := s.run(ctx, func(db querier) error {
+		return db.QueryRow(ctx, `
+SELECT resource_version FROM k8s_resource_versions
+WHERE cluster_uid = $1 AND resource = $2;
+`, clusterUID, resource).Scan(&resourceVersion)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read resource version %s/%s: %w", clusterUID, resource, err)
+	}
+	return resourceVersion, nil
+}
+
+// SaveResourceVersion records the last resourceVersion applied to the store.
+func (s *Store) SaveResourceVersion(ctx context.Context, clusterUID, resource, resourceVersion string) error {
+	_, err := s.exec(ctx, `
+INSERT INTO k8s_resource_versions (cluster_uid, resource, resource_version, updated_at)
+VALUES ($1, $2, $3, now())
+ON CONFLICT (cluster_uid, resource)
+DO UPDATE SET resource_version = EXCLUDED.resource_version,
+	updated_at = EXCLUDED.updated_at;
+`, clusterUID, resource, resourceVersion)
+	if err != nil {
+		return fmt.Errorf("save resource version %s/%s: %w", clusterUID, resource, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// syncErrorsSQL creates the table recording why a context or resource could
+// not be synced, so a cluster without access does not look empty.
+const syncErrorsSQL = `
+CREATE TABLE IF NOT EXISTS k8s_sync_errors (
+	id UUID PRIMARY KEY,
+	sync_id UUID NOT NULL,
+	context_name TEXT,
+	cluster_uid TEXT,
+	resource TEXT,
+	status_code BIGINT,
+	reason TEXT,
+	message TEXT NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS k8s_sync_errors_occurred_at_idx ON k8s_sync_errors (occurred_at);
+`
+
+// SyncError is a failure of a whole context (Resource empty) or of one
+// resource during a sync.
+type SyncError struct {
+	ID          string
+	SyncID      string
+	ContextName string
+	ClusterUID  string
+	Resource    string
+	// StatusCode is the HTTP status of a failed API request, 0 otherwise.
+	StatusCode int32
+	// Reason is the API status reason, e.g. "Forbidden", or a short kind of
+	// failure for errors that did not come from the API server.
+	Reason     string
+	Message    string
+	OccurredAt time.Time
+}
+
+// ErrorDetails extracts the HTTP status and reason of err.
+func ErrorDetails(err error) (int32, string) {
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code, string(status.Status().Reason)
+	}
+	var batch *BatchError
+	switch {
+	case errors.As(err, &batch):
+		return 0, "WriteFailed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return 0, "Timeout"
+	case errors.Is(err, context.Canceled):
+		return 0, "Canceled"
+	}
+	return 0, "Error"
+}
+
+// InsertSyncErrors records errs in k8s_sync_errors.
+func (s *Store) InsertSyncErrors(ctx context.Context, errs []SyncError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, e := range errs {
+		var statusCode *int32
+		if e.StatusCode != 0 {
+			statusCode = &e.StatusCode
+		}
+		batch.Queue(`
+INSERT INTO k8s_sync_errors (id, sync_id, context_name, cluster_uid, resource, status_code, reason, message, occurred_at)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, $9)
+ON CONFLICT (id) DO NOTHING;
+`, e.ID, e.SyncID, e.ContextName, e.ClusterUID, e.Resource, statusCode, e.Reason, e.Message, e.OccurredAt)
+	}
+	err := s.run(ctx, func(db querier) error {
+		return db.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return fmt.Errorf("insert sync errors: %w", err)
+	}
+	return nil
+}
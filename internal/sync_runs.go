@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// syncRunsSQL creates the ledger of sync runs and of what each run did per
+// context and resource.
+const syncRunsSQL = `
+CREATE TABLE IF NOT EXISTS k8s_sync_runs (
+	sync_id UUID PRIMARY KEY,
+	plugin_version TEXT NOT NULL,
+	status TEXT NOT NULL,
+	incremental BOOLEAN NOT NULL,
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL,
+	context_count BIGINT NOT NULL,
+	row_count BIGINT NOT NULL,
+	error_count BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS k8s_sync_runs_started_at_idx ON k8s_sync_runs (started_at);
+
+CREATE TABLE IF NOT EXISTS k8s_sync_run_resources (
+	sync_id UUID NOT NULL,
+	context_name TEXT NOT NULL,
+	resource TEXT NOT NULL,
+	cluster_uid TEXT,
+	table_name TEXT NOT NULL,
+	status TEXT NOT NULL,
+	started_at TIMESTAMPTZ NOT NULL,
+	finished_at TIMESTAMPTZ NOT NULL,
+	row_count BIGINT NOT NULL,
+	inserted BIGINT NOT NULL,
+	updated BIGINT NOT NULL,
+	deleted BIGINT NOT NULL,
+	error_count BIGINT NOT NULL,
+	PRIMARY KEY (sync_id, context_name, resource)
+);
+CREATE INDEX IF NOT EXISTS k8s_sync_run_resources_cluster_idx ON k8s_sync_run_resources (cluster_uid, started_at);
+`
+
+// Statuses of a sync run and of its resources.
+const (
+	SyncSucceeded = "succeeded"
+	// SyncPartial is a run where some contexts or resources failed.
+	SyncPartial = "partial"
+	SyncFailed  = "failed"
+)
+
+// SyncRun summarizes one sync.
+type SyncRun struct {
+	SyncID        string
+	PluginVersion string
+	Status        string
+	Incremental   bool
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	ContextCount  int64
+	RowCount      int64
+	ErrorCount    int64
+}
+
+// SyncRunResource is what a sync did for one resource of one context.
+type SyncRunResource struct {
+	SyncID      string
+	ContextName string
+	Resource    string
+	ClusterUID  string
+	Table       string
+	// Status is SyncFailed when the resource or its context failed, in
+	// which case the writes of a database_url snapshot were rolled back.
+	Status     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// RowCount is the number of rows emitted. Inserted, Updated and Deleted
+	// count the database_url writes and are 0 without it.
+	RowCount   int64
+	Inserted   int64
+	Updated    int64
+	Deleted    int64
+	ErrorCount int64
+	// LabelSelector, FieldSelector and Namespaces are the filters the
+	// resource was listed with; Namespaces is nil when none applied.
+	LabelSelector string
+	FieldSelector string
+	Namespaces    *NamespaceFilter
+}
+
+// InsertSyncRun records run and its resources in the sync ledger.
+func (s *Store) InsertSyncRun(ctx context.Context, run SyncRun, resources []SyncRunResource) error {
+	batch := &pgx.Batch{}
+	batch.Queue(`
+INSERT INTO k8s_sync_runs (sync_id, plugin_version, status, incremental, started_at, finished_at, context_count, row_count, error_count)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (sync_id) DO NOTHING;
+`, run.SyncID, run.PluginVersion, run.Status, run.Incremental, run.StartedAt, run.FinishedAt, run.ContextCount, run.RowCount, run.ErrorCount)
+	for _, r := range resources {
+		batch.Queue(`
+INSERT INTO k8s_sync_run_resources (sync_id, context_name, resource, cluster_uid, table_name, status, started_at, finished_at, row_count, inserted, updated, deleted, error_count, label_selector, field_selector, namespace_filter)
+VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, ''), NULLIF($15, ''), $16)
+ON CONFLICT (sync_id, context_name, resource) DO NOTHING;
+`, r.SyncID, r.ContextName, r.Resource, r.ClusterUID, r.Table, r.Status, r.StartedAt, r.FinishedAt, r.RowCount, r.Inserted, r.Updated, r.Deleted, r.ErrorCount,
+			r.LabelSelector, r.FieldSelector, r.Namespaces)
+	}
+	err := s.run(ctx, func(db querier) error {
+		return db.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return fmt.Errorf("insert sync run: %w", err)
+	}
+	return nil
+}
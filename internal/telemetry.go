@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer and meter of the plugin.
+const InstrumentationName = "github.com/Genos0820/cq-k8s-custom"
+
+// Attribute keys of spans and metrics.
+const (
+	ContextKey    = attribute.Key("k8s.context")
+	ClusterUIDKey = attribute.Key("k8s.cluster.uid")
+	ResourceKey   = attribute.Key("k8s.resource")
+	TableKey      = attribute.Key("db.collection.name")
+	StatusCodeKey = attribute.Key("http.response.status_code")
+	ReasonKey     = attribute.Key("error.type")
+	RowsKey       = attribute.Key("db.response.returned_rows")
+)
+
+// durationBuckets are the histogram boundaries in seconds; the SDK defaults
+// suit milliseconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// The tracer and instruments come from the global providers, so they report
+// to whatever the SDK or watch mode installs, and do nothing otherwise.
+var (
+	tracer = otel.Tracer(InstrumentationName)
+	meter  = otel.Meter(InstrumentationName)
+
+	objectsFetched, _ = meter.Int64Counter("k8s.objects.fetched",
+		metric.WithDescription("Objects fetched from the API server."),
+		metric.WithUnit("{object}"))
+	listDuration, _ = meter.Float64Histogram("k8s.list.duration",
+		metric.WithDescription("Duration of listing a resource, across all pages."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	writeDuration, _ = meter.Float64Histogram("k8s.store.write.duration",
+		metric.WithDescription("Duration of a database_url write."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	syncErrors, _ = meter.Int64Counter("k8s.api.errors",
+		metric.WithDescription("Failed lists and writes, by HTTP status and reason."),
+		metric.WithUnit("{error}"))
+	retries, _ = meter.Int64Counter("k8s.api.retries",
+		metric.WithDescription("Retried API requests."),
+		metric.WithUnit("{retry}"))
+)
+
+// StartSpan starts a span named name under the span of ctx.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends span, marking it failed with err when set.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// RecordObject counts an object fetched for resource of contextName.
+func RecordObject(ctx context.Context, contextName, resource string) {
+	objectsFetched.Add(ctx, 1, metric.WithAttributes(ContextKey.String(contextName), ResourceKey.String(resource)))
+}
+
+// RecordList records how long listing resource of contextName took.
+func RecordList(ctx context.Context, contextName, resource string, started time.Time, err error) {
+	attrs := []attribute.KeyValue{ContextKey.String(contextName), ResourceKey.String(resource)}
+	if err != nil {
+		_, reason := ErrorDetails(err)
+		attrs = append(attrs, ReasonKey.String(reason))
+	}
+	listDuration.Record(ctx, time.Since(started).Seconds(), metric.WithAttributes(attrs...))
+}
+
+// RecordError counts a failed list or write of resource of contextName.
+// Context-wide failures have an empty resource.
+func RecordError(ctx context.Context, contextName, resource string, err error) {
+	statusCode, reason := ErrorDetails(err)
+	syncErrors.Add(ctx, 1, metric.WithAttributes(
+		ContextKey.String(contextName),
+		ResourceKey.String(resource),
+		StatusCodeKey.Int(int(statusCode)),
+		ReasonKey.String(reason),
+	))
+}
+
+// RecordRetry counts a request of contextName that is retried.
+func RecordRetry(ctx context.Context, contextName string, retry Retry) {
+	retries.Add(ctx, 1, metric.WithAttributes(ContextKey.String(contextName), StatusCodeKey.Int(retry.StatusCode)))
+}
+
+func recordWrite(ctx context.Context, table string, started time.Time, err error) {
+	attrs := []attribute.KeyValue{TableKey.String(table)}
+	if err != nil {
+		attrs = append(attrs, ReasonKey.String("WriteFailed"))
+	}
+	writeDuration.Record(ctx, time.Since(started).Seconds(), metric.WithAttributes(attrs...))
+}
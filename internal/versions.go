@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VersionBackend persists resourceVersion bookmarks per cluster and resource.
+// Store implements it on top of k8s_resource_versions.
+type VersionBackend interface {
+	ResourceVersion(ctx context.Context, clusterUID, resource string) (string, error)
+	SaveResourceVersion(ctx context.Context, clusterUID, resource, resourceVersion string) error
+}
+
+// ResourceVersions tracks the bookmarks of one context during an incremental
+// sync. Observed versions are only saved by Commit, once the rows they cover
+// have been written.
+type ResourceVersions struct {
+	backend    VersionBackend
+	clusterUID string
+	// Window is how long the catch-up watch runs before the API server ends it.
+	Window time.Duration
+
+	mu          sync.Mutex
+	pending     map[string]string
+	incremental map[string]struct{}
+	deleted     map[string][]string
+}
+
+func NewResourceVersions(backend VersionBackend, clusterUID string, window time.Duration) *ResourceVersions {
+	return &ResourceVersions{
+		backend:     backend,
+		clusterUID:  clusterUID,
+		Window:      window,
+		pending:     map[string]string{},
+		incremental: map[string]struct{}{},
+		deleted:     map[string][]string{},
+	}
+}
+
+// Get returns the saved bookmark for resource, or "" when there is none.
+func (v *ResourceVersions) Get(ctx context.Context, resource string) (string, error) {
+	return v.backend.ResourceVersion(ctx, v.clusterUID, resource)
+}
+
+// Observe records the resourceVersion the rows fetched for resource are current as of.
+func (v *ResourceVersions) Observe(resource, resourceVersion string, incremental bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pending[resource] = resourceVersion
+	if incremental {
+		v.incremental[resource] = struct{}{}
+	} else {
+		delete(v.incremental, resource)
+	}
+}
+
+// Delete records an object the watch reported as deleted. Deletes are applied
+// after the sync's writes, so a queued upsert cannot bring the row back.
+func (v *ResourceVersions) Delete(resource, uid string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.deleted[resource] = append(v.deleted[resource], uid)
+}
+
+// Deleted returns the UIDs recorded by Delete for resource.
+func (v *ResourceVersions) Deleted(resource string) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var uids []string
+	for key, deleted := range v.deleted {
+		if keyOf(key, resource) {
+			uids = append(uids, deleted...)
+		}
+	}
+	return uids
+}
+
+// Incremental reports which rows of resource were fetched as changes only,
+// so rows not seen in this sync are not stale: all of them when a
+// cluster-wide bookmark was used, otherwise those in namespaces.
+func (v *ResourceVersions) Incremental(resource string) (namespaces []string, all bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key := range v.incremental {
+		if !keyOf(key, resource) {
+			continue
+		}
+		namespaced, ok := strings.CutPrefix(key, resource+"/")
+		if !ok {
+			return nil, true
+		}
+		namespace, _, _ := strings.Cut(namespaced, "#")
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces, false
+}
+
+// Commit saves the observed bookmarks for resource.
+func (v *ResourceVersions) Commit(ctx context.Context, resource string) error {
+	v.mu.Lock()
+	pending := map[string]string{}
+	for key, resourceVersion := range v.pending {
+		if keyOf(key, resource) && resourceVersion != "" {
+			pending[key] = resourceVersion
+		}
+	}
+	v.mu.Unlock()
+	for key, resourceVersion := range pending {
+		if err := v.backend.SaveResourceVersion(ctx, v.clusterUID, key, resourceVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NamespacedKey is the bookmark key of resource when it is listed one
+// namespace at a time.
+func NamespacedKey(resource, namespace string) string {
+	return resource + "/" + namespace
+}
+
+// keyOf reports whether key is the bookmark of resource, of one of its
+// namespaces or of a selector (see SelectorKey).
+func keyOf(key, resource string) bool {
+	return key == resource || strings.HasPrefix(key, resource+"/") || strings.HasPrefix(key, resource+"#")
+}
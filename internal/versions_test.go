@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResourceVersionsIncremental(t *testing.T) {
+	tests := []struct {
+		name     string
+		observed map[string]bool
+		want     []string
+		wantAll  bool
+	}{
+		{
+			name:     "full list",
+			observed: map[string]bool{"pods": false},
+		},
+		{
+			name:     "cluster-wide changes",
+			observed: map[string]bool{"pods": true},
+			wantAll:  true,
+		},
+		{
+			name:     "cluster-wide changes with a selector",
+			observed: map[string]bool{"pods#app=web#": true},
+			wantAll:  true,
+		},
+		{
+			name: "changes in some namespaces",
+			observed: map[string]bool{
+				"pods/payments": true,
+				"pods/default":  true,
+				"pods/billing":  false,
+			},
+			want: []string{"default", "payments"},
+		},
+		{
+			name:     "namespace with a selector",
+			observed: map[string]bool{"pods/default#app=web#": true},
+			want:     []string{"default"},
+		},
+		{
+			name: "other resources",
+			observed: map[string]bool{
+				"podtemplates":      true,
+				"services/payments": true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			versions := NewResourceVersions(nil, "cluster", 0)
+			for key, incremental := range tt.observed {
+				versions.Observe(key, "1", incremental)
+			}
+			namespaces, all := versions.Incremental("pods")
+			if all != tt.wantAll {
+				t.Errorf("all = %v, want %v", all, tt.wantAll)
+			}
+			if !all && !slices.Equal(namespaces, tt.want) {
+				t.Errorf("namespaces = %v, want %v", namespaces, tt.want)
+			}
+		})
+	}
+}
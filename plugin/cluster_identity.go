@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+// identifyCluster sets the cluster_uid of client: override when set,
+// otherwise the UID of the kube-system namespace, which survives endpoint
+// and load balancer changes. When kube-system is forbidden or missing, the
+// cluster_uid store recorded for the server and context is reused, and the
+// server hash is the last resort; other errors fail the context. With a
+// store, the other IDs of the cluster are recorded in k8s_cluster_aliases and
+// rows stored under them are moved over. Addresses many clusters share
+// (in-cluster, loopback) are never used as an alias.
+func (c *SourceClient) identifyCluster(ctx context.Context, client *internal.Client, store *internal.Store, override string) error {
+	logger := c.logger.With().Str("context", client.Context()).Logger()
+	serverUID := internal.ServerUID(client)
+	shared := internal.SharedServer(client)
+	var aliases []string
+	if !shared {
+		aliases = append(aliases, serverUID)
+	}
+
+	switch {
+	case override != "":
+		client.ClusterUID, client.ClusterUIDSource = override, internal.ClusterUIDOverride
+		if systemUID, err := client.KubeSystemUID(ctx); err == nil {
+			aliases = append(aliases, systemUID)
+		}
+	default:
+		systemUID, err := client.KubeSystemUID(ctx)
+		if err == nil {
+			client.ClusterUID, client.ClusterUIDSource = systemUID, internal.ClusterUIDKubeSystem
+			break
+		}
+		// Only a lasting answer falls back; a transient failure would sync
+		// the whole context under another cluster_uid.
+		if !apierrors.IsForbidden(err) && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("identify cluster: %w", err)
+		}
+		logger.Warn().Err(err).Msg("failed to read the kube-system namespace, identifying the cluster by its server")
+		client.ClusterUID, client.ClusterUIDSource = serverUID, internal.ClusterUIDServer
+		if store == nil || shared {
+			break
+		}
+		known, err := store.ClusterAlias(ctx, serverUID, client.Context())
+		if err != nil {
+			return err
+		}
+		if known != "" {
+			client.ClusterUID, client.ClusterUIDSource = known, internal.ClusterUIDAlias
+		}
+	}
+
+	if store == nil {
+		return nil
+	}
+	server := ""
+	if client.Config != nil {
+		server = client.Config.Host
+	}
+	moved, err := store.AdoptClusterAliases(ctx, client.ClusterUID, aliases, client.Context(), server)
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		logger.Info().Int64("rows", moved).Str("cluster_uid", client.ClusterUID).Msg("moved rows stored under an old cluster_uid")
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+func fetchClusters(
+	ctx context.Context,
+	meta schema.ClientMeta,
+	parent *schema.Resource,
+	res chan<- interface{},
+) error {
+	c := meta.(*internal.Client)
+	logger := zerolog.Ctx(ctx)
+	contextName := c.Context()
+
+	config := c.Config
+	server := ""
+	caFile := ""
+	insecureSkipVerify := false
+	clusterName := contextName
+	namespace := "default"
+	kubernetesVersion := ""
+	// nodeCount stays NULL when the nodes cannot be listed, rather than
+	// reporting a cluster without nodes.
+	var nodeCount any
+	var errs []error
+
+	if c.InCluster {
+		namespace = internal.InClusterNamespace()
+	} else if contextCluster, contextNamespace, err := internal.GetContextDetails(c.Kubeconfig, contextName); err != nil {
+		logger.Warn().Err(err).Str("context", contextName).Msg("failed to read context details")
+	} else {
+		if contextCluster != "" {
+			clusterName = contextCluster
+		}
+		if contextNamespace != "" {
+			namespace = contextNamespace
+		}
+	}
+
+	if config != nil && config.Host != "" {
+		server = config.Host
+		if config.CAFile != "" {
+			caFile = config.CAFile
+		}
+		insecureSkipVerify = config.Insecure
+	}
+
+	if versionInfo, err := c.Clientset.Discovery().ServerVersion(); err != nil {
+		logger.Warn().Err(err).Str("context", contextName).Msg("failed to read kubernetes version")
+		errs = append(errs, fmt.Errorf("read kubernetes version: %w", err))
+	} else if versionInfo != nil {
+		kubernetesVersion = versionInfo.GitVersion
+	}
+
+	nodes := int64(0)
+	err := internal.ListPages(ctx, metav1.ListOptions{}, c.PageSize, c.Clientset.CoreV1().Nodes().List, func(page *corev1.NodeList) error {
+		nodes += int64(len(page.Items))
+		return nil
+	})
+	if err != nil {
+		logger.Warn().Err(err).Str("context", contextName).Msg("failed to list nodes")
+		errs = append(errs, fmt.Errorf("count nodes: %w", err))
+	} else {
+		nodeCount = nodes
+	}
+
+	now := time.Now()
+	res <- map[string]interface{}{
+		"cluster_uid":          c.ClusterUID,
+		"uid_source":           c.ClusterUIDSource,
+		"context_name":         contextName,
+		"cluster_name":         clusterName,
+		"server":               server,
+		"ca_file":              caFile,
+		"insecure_skip_verify": insecureSkipVerify,
+		"namespace":            namespace,
+		"kubernetes_version":   kubernetesVersion,
+		"node_count":           nodeCount,
+		"tags":                 c.Tags,
+		"sync_filters":         syncFilters(c),
+		"synced_at":            now,
+		"created_at":           now,
+		"updated_at":           now,
+	}
+	if len(errs) > 0 {
+		return &partialError{errs: errs}
+	}
+	return nil
+}
+
+// syncFilters describes the namespace filter and selectors the context was
+// synced with, so filtered syncs can be told apart from full ones. It is nil
+// for a full sync.
+func syncFilters(c *internal.Client) any {
+	filters := map[string]any{}
+	if !c.Namespaces.IsZero() {
+		filters["namespaces"] = c.Namespaces
+	}
+	selectors := map[string]internal.Selector{}
+	for resource, selector := range c.Selectors {
+		if !selector.IsZero() {
+			selectors[resource] = selector
+		}
+	}
+	if len(selectors) > 0 {
+		filters["selectors"] = selectors
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return filters
+}
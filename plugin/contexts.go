@@ -0,0 +1,403 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+// ContextSpec is an entry of Config.Contexts: a context name or pattern,
+// optionally with settings that override the top-level ones for the
+// contexts it matches. In the spec it is either a plain string or an
+// object with a name.
+type ContextSpec struct {
+	Name           string            `json:"name"`
+	Resources      []string          `json:"resources,omitempty"`
+	ContextTimeout string            `json:"context_timeout,omitempty"`
+	PageSize       int64             `json:"page_size,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	// Namespaces replaces the top-level namespace filter.
+	Namespaces *internal.NamespaceFilter `json:"namespaces,omitempty"`
+	// Selectors replace the top-level selectors of the resources they name.
+	Selectors map[string]internal.Selector `json:"selectors,omitempty"`
+	// Impersonate replaces the top-level impersonation.
+	Impersonate *internal.Impersonation `json:"impersonate,omitempty"`
+	// ClusterUID fixes the cluster_uid of the context instead of deriving
+	// it from the kube-system namespace. Only allowed for exact names.
+	ClusterUID string `json:"cluster_uid,omitempty"`
+}
+
+func (s *ContextSpec) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*s = ContextSpec{Name: name}
+		return nil
+	}
+	type plain ContextSpec
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+// merge returns s with the settings o sets. Resources are replaced, tags
+// are added to.
+func (s ContextSpec) merge(o ContextSpec) ContextSpec {
+	if len(o.Resources) > 0 {
+		s.Resources = o.Resources
+	}
+	if o.ContextTimeout != "" {
+		s.ContextTimeout = o.ContextTimeout
+	}
+	if o.PageSize > 0 {
+		s.PageSize = o.PageSize
+	}
+	if o.Namespaces != nil {
+		s.Namespaces = o.Namespaces
+	}
+	if len(o.Selectors) > 0 {
+		s.Selectors = mergeSelectors(s.Selectors, o.Selectors)
+	}
+	if o.Impersonate != nil {
+		s.Impersonate = o.Impersonate
+	}
+	if o.ClusterUID != "" {
+		s.ClusterUID = o.ClusterUID
+	}
+	if len(o.Tags) > 0 {
+		tags := maps.Clone(s.Tags)
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		maps.Copy(tags, o.Tags)
+		s.Tags = tags
+	}
+	return s
+}
+
+func (s ContextSpec) validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("contexts: every entry needs a name")
+	}
+	pattern, err := parseContextPattern(strings.TrimSpace(s.Name))
+	if err != nil {
+		return err
+	}
+	if s.ClusterUID != "" && !pattern.exact() {
+		// One ID for several contexts would merge their clusters.
+		return fmt.Errorf("context %s: cluster_uid needs an exact context name", s.Name)
+	}
+	if s.ContextTimeout != "" {
+		if _, err := time.ParseDuration(s.ContextTimeout); err != nil {
+			return fmt.Errorf("context %s: invalid context_timeout: %w", s.Name, err)
+		}
+	}
+	if s.Namespaces != nil {
+		if err := s.Namespaces.Validate(); err != nil {
+			return fmt.Errorf("context %s: %w", s.Name, err)
+		}
+	}
+	if err := validateSelectors(s.Selectors); err != nil {
+		return fmt.Errorf("context %s: %w", s.Name, err)
+	}
+	if s.Impersonate != nil {
+		if err := s.Impersonate.Validate(); err != nil {
+			return fmt.Errorf("context %s: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateSelectors checks that selectors name known resources and parse.
+func validateSelectors(selectors map[string]internal.Selector) error {
+	for resource, selector := range selectors {
+		if !selectableResource(resource) {
+			return fmt.Errorf("selectors: unknown resource %q", resource)
+		}
+		if err := selector.Validate(); err != nil {
+			return fmt.Errorf("selectors %s: %w", resource, err)
+		}
+	}
+	return nil
+}
+
+// mergeSelectors returns base with the selectors of override replacing
+// those of the same resource.
+func mergeSelectors(base, override map[string]internal.Selector) map[string]internal.Selector {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = map[string]internal.Selector{}
+	}
+	maps.Copy(merged, override)
+	return merged
+}
+
+func contextSpecs(names []string) []ContextSpec {
+	specs := make([]ContextSpec, len(names))
+	for i, name := range names {
+		specs[i] = ContextSpec{Name: name}
+	}
+	return specs
+}
+
+// contextSettings are the effective settings of one context.
+type contextSettings struct {
+	resourceFilter map[string]struct{}
+	timeout        time.Duration
+	pageSize       int64
+	tags           map[string]string
+	namespaces     internal.NamespaceFilter
+	selectors      map[string]internal.Selector
+	client         internal.ClientOptions
+	// clusterUID overrides the derived cluster_uid when set.
+	clusterUID string
+}
+
+// settingsFor applies override to the top-level settings.
+func (c *SourceClient) settingsFor(override ContextSpec) contextSettings {
+	settings := contextSettings{
+		resourceFilter: c.resourceFilter,
+		timeout:        c.contextTimeout,
+		pageSize:       c.spec.PageSize,
+		tags:           c.spec.Tags,
+		namespaces:     c.spec.Namespaces,
+		selectors:      c.spec.Selectors,
+		client:         c.clientOptions,
+	}
+	if len(override.Resources) > 0 {
+		settings.resourceFilter = sliceToSet(override.Resources)
+	}
+	if override.ContextTimeout != "" {
+		settings.timeout, _ = time.ParseDuration(override.ContextTimeout)
+	}
+	if override.PageSize > 0 {
+		settings.pageSize = override.PageSize
+	}
+	if override.Namespaces != nil {
+		settings.namespaces = *override.Namespaces
+	}
+	if len(override.Selectors) > 0 {
+		settings.selectors = mergeSelectors(c.spec.Selectors, override.Selectors)
+	}
+	if override.Impersonate != nil {
+		settings.client.Impersonate = *override.Impersonate
+	}
+	settings.clusterUID = override.ClusterUID
+	if len(override.Tags) > 0 {
+		settings.tags = maps.Clone(c.spec.Tags)
+		if settings.tags == nil {
+			settings.tags = map[string]string{}
+		}
+		maps.Copy(settings.tags, override.Tags)
+	}
+	return settings
+}
+
+// contextPattern matches kube context names. "*" and other globs use
+// path.Match syntax, except that "*" and "?" also match "/" so globs cover
+// EKS ARNs ("arn:aws:eks:<region>:<account>:cluster/<name>"). Values wrapped
+// in slashes ("/^prod-(eu|us)$/") are regular expressions, and anything else
+// is an exact name.
+type contextPattern struct {
+	value string
+	re    *regexp.Regexp
+	glob  bool
+}
+
+func parseContextPatterns(values []string) ([]contextPattern, error) {
+	patterns := make([]contextPattern, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		pattern, err := parseContextPattern(value)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func parseContextPattern(value string) (contextPattern, error) {
+	pattern := contextPattern{value: value}
+	switch {
+	case len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/"):
+		re, err := regexp.Compile(value[1 : len(value)-1])
+		if err != nil {
+			return pattern, fmt.Errorf("invalid context pattern %q: %w", value, err)
+		}
+		pattern.re = re
+	case strings.ContainsAny(value, "*?["):
+		if _, err := path.Match(value, ""); err != nil {
+			return pattern, fmt.Errorf("invalid context pattern %q: %w", value, err)
+		}
+		re, err := regexp.Compile(globExpr(value))
+		if err != nil {
+			return pattern, fmt.Errorf("invalid context pattern %q: %w", value, err)
+		}
+		pattern.re = re
+		pattern.glob = true
+	}
+	return pattern, nil
+}
+
+// globExpr translates a glob that path.Match accepts into an anchored
+// regular expression in which "*" and "?" also match "/".
+func globExpr(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	inClass := false
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			b.WriteByte(c)
+		case c == '*':
+			b.WriteString("(?s:.*)")
+		case c == '?':
+			b.WriteString("(?s:.)")
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+			if i+1 < len(glob) && glob[i+1] == '^' {
+				i++
+				b.WriteByte('^')
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func (p contextPattern) exact() bool {
+	return p.re == nil && !p.glob
+}
+
+func (p contextPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	return p.value == name
+}
+
+func matchesAny(patterns []contextPattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// contextTarget is a resolved kube context and the settings it syncs with.
+type contextTarget struct {
+	name string
+	// override merges every ContextSpec matching the context, in spec order.
+	override ContextSpec
+	settings contextSettings
+}
+
+// resolveContexts returns the contexts selected by specs and exclude. nil
+// means the kubeconfig's current context. Exact names are kept even if the
+// kubeconfig does not define them (e.g. the in-cluster context); patterns
+// are matched against the contexts in the kubeconfig, so newly added ones
+// are picked up without changing the spec.
+func resolveContexts(kubeconfig internal.Kubeconfig, specs []ContextSpec, exclude []string) ([]contextTarget, error) {
+	var includes []contextPattern
+	var includeSpecs []ContextSpec
+	for _, spec := range specs {
+		spec.Name = strings.TrimSpace(spec.Name)
+		if spec.Name == "" {
+			continue
+		}
+		pattern, err := parseContextPattern(spec.Name)
+		if err != nil {
+			return nil, err
+		}
+		includes = append(includes, pattern)
+		includeSpecs = append(includeSpecs, spec)
+	}
+	excludes, err := parseContextPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	if len(includes) == 0 {
+		if len(excludes) == 0 {
+			return nil, nil
+		}
+		all, _ := parseContextPattern("*")
+		includes = []contextPattern{all}
+		includeSpecs = []ContextSpec{{Name: "*"}}
+	}
+
+	var available []string
+	for _, pattern := range includes {
+		if !pattern.exact() {
+			if available, err = internal.GetAvailableContexts(kubeconfig); err != nil {
+				return nil, fmt.Errorf("list kube contexts: %w", err)
+			}
+			sort.Strings(available)
+			break
+		}
+	}
+
+	index := map[string]int{}
+	var targets []contextTarget
+	add := func(name string, spec ContextSpec) {
+		if matchesAny(excludes, name) {
+			return
+		}
+		if i, ok := index[name]; ok {
+			targets[i].override = targets[i].override.merge(spec)
+			return
+		}
+		index[name] = len(targets)
+		targets = append(targets, contextTarget{name: name, override: ContextSpec{}.merge(spec)})
+	}
+	for i, pattern := range includes {
+		if pattern.exact() {
+			add(pattern.value, includeSpecs[i])
+			continue
+		}
+		for _, name := range available {
+			if pattern.match(name) {
+				add(name, includeSpecs[i])
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		names := make([]string, len(includes))
+		for i, pattern := range includes {
+			names[i] = pattern.value
+		}
+		return nil, fmt.Errorf("no kube contexts match contexts %v excluding %v", names, exclude)
+	}
+	return targets, nil
+}
+
+// selectableResource reports whether resource is listed through the API
+// server and so can take a selector; k8s_clusters is not.
+func selectableResource(resource string) bool {
+	for _, name := range tableResources {
+		if name == resource {
+			return resource != "clusters"
+		}
+	}
+	return false
+}
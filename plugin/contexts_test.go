@@ -0,0 +1,296 @@
+package plugin
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+func TestParseContextPattern(t *testing.T) {
+	tests := []struct {
+		value   string
+		exact   bool
+		matches []string
+		misses  []string
+		wantErr bool
+	}{
+		{value: "prod", exact: true, matches: []string{"prod"}, misses: []string{"prod-eu", "Prod"}},
+		{value: "*", matches: []string{"dev", "prod-eu"}},
+		{value: "prod-*", matches: []string{"prod-eu", "prod-", "prod-eu/canary"}, misses: []string{"prod", "dev-prod-eu"}},
+		{value: "prod-?", matches: []string{"prod-1"}, misses: []string{"prod-10"}},
+		{value: "prod-[ab]", matches: []string{"prod-a", "prod-b"}, misses: []string{"prod-c"}},
+		{value: "prod-[^ab]", matches: []string{"prod-c"}, misses: []string{"prod-a"}},
+		{value: `prod\*`, matches: []string{"prod*"}, misses: []string{"prod-eu"}},
+		{value: "prod.eu", exact: true, matches: []string{"prod.eu"}, misses: []string{"prod-eu"}},
+		// Globs match EKS ARNs, whose names contain "/".
+		{value: "*", matches: []string{"arn:aws:eks:us-east-1:123456789012:cluster/prod"}},
+		{
+			value:   "arn:aws:eks:*:cluster/prod-*",
+			matches: []string{"arn:aws:eks:us-east-1:123456789012:cluster/prod-eu"},
+			misses:  []string{"arn:aws:eks:us-east-1:123456789012:cluster/dev"},
+		},
+		{value: "*/prod", matches: []string{"arn:aws:eks:eu-west-1:123456789012:cluster/prod"}, misses: []string{"prod"}},
+		{value: "a?b", matches: []string{"a/b"}},
+		{value: "/^prod-(eu|us)$/", matches: []string{"prod-eu", "prod-us"}, misses: []string{"prod-ap", "xprod-eu"}},
+		{value: "/eu/", matches: []string{"prod-eu-1"}, misses: []string{"prod-us"}},
+		// A single slash is a name, not an empty regular expression.
+		{value: "/", exact: true, matches: []string{"/"}},
+		{value: "/(/", wantErr: true},
+		{value: "prod-[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			pattern, err := parseContextPattern(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pattern.exact() != tt.exact {
+				t.Errorf("exact() = %v, want %v", pattern.exact(), tt.exact)
+			}
+			for _, name := range tt.matches {
+				if !pattern.match(name) {
+					t.Errorf("%q does not match %q", tt.value, name)
+				}
+			}
+			for _, name := range tt.misses {
+				if pattern.match(name) {
+					t.Errorf("%q matches %q", tt.value, name)
+				}
+			}
+		})
+	}
+}
+
+func TestContextSpecMerge(t *testing.T) {
+	prodNamespaces := &internal.NamespaceFilter{Include: []string{"payments"}}
+	readOnly := &internal.Impersonation{User: "inventory-reader"}
+
+	tests := []struct {
+		name     string
+		base     ContextSpec
+		override ContextSpec
+		want     ContextSpec
+	}{
+		{
+			name:     "empty override keeps everything",
+			base:     ContextSpec{Name: "prod", Resources: []string{"pods"}, PageSize: 100, Tags: map[string]string{"env": "prod"}},
+			override: ContextSpec{Name: "*"},
+			want:     ContextSpec{Name: "prod", Resources: []string{"pods"}, PageSize: 100, Tags: map[string]string{"env": "prod"}},
+		},
+		{
+			name: "settings are replaced",
+			base: ContextSpec{Resources: []string{"pods"}, ContextTimeout: "1m", PageSize: 100, ClusterUID: "a"},
+			override: ContextSpec{
+				Resources:      []string{"namespaces", "services"},
+				ContextTimeout: "1h",
+				PageSize:       50,
+				Namespaces:     prodNamespaces,
+				Impersonate:    readOnly,
+				ClusterUID:     "b",
+			},
+			want: ContextSpec{
+				Resources:      []string{"namespaces", "services"},
+				ContextTimeout: "1h",
+				PageSize:       50,
+				Namespaces:     prodNamespaces,
+				Impersonate:    readOnly,
+				ClusterUID:     "b",
+			},
+		},
+		{
+			name:     "tags are added to",
+			base:     ContextSpec{Tags: map[string]string{"owner": "platform", "env": "dev"}},
+			override: ContextSpec{Tags: map[string]string{"env": "prod"}},
+			want:     ContextSpec{Tags: map[string]string{"owner": "platform", "env": "prod"}},
+		},
+		{
+			name: "selectors replace those of the same resource",
+			base: ContextSpec{Selectors: map[string]internal.Selector{
+				"pods":     {LabelSelector: "app=web"},
+				"services": {LabelSelector: "team=payments"},
+			}},
+			override: ContextSpec{Selectors: map[string]internal.Selector{
+				"pods": {FieldSelector: "status.phase!=Succeeded"},
+			}},
+			want: ContextSpec{Selectors: map[string]internal.Selector{
+				"pods":     {FieldSelector: "status.phase!=Succeeded"},
+				"services": {LabelSelector: "team=payments"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.base.merge(tt.override)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("base tags are not modified", func(t *testing.T) {
+		base := ContextSpec{Tags: map[string]string{"env": "dev"}}
+		base.merge(ContextSpec{Tags: map[string]string{"env": "prod"}})
+		if base.Tags["env"] != "dev" {
+			t.Errorf("base tags changed to %v", base.Tags)
+		}
+	})
+}
+
+func TestResolveContexts(t *testing.T) {
+	const (
+		eksProd = "arn:aws:eks:us-east-1:123456789012:cluster/prod"
+		eksDev  = "arn:aws:eks:us-east-1:123456789012:cluster/dev"
+	)
+	kubeconfig := writeKubeconfig(t, "dev", "prod-eu", "prod-us", "prod-eu-canary", eksProd, eksDev)
+
+	tests := []struct {
+		name    string
+		specs   []ContextSpec
+		exclude []string
+		want    []string
+		// tags are the merged tags of each resolved context, if checked.
+		tags    map[string]map[string]string
+		wantErr string
+	}{
+		{
+			name: "nothing selected means the current context",
+			want: nil,
+		},
+		{
+			name:  "exact names are kept in order",
+			specs: contextSpecs([]string{"prod-us", "dev"}),
+			want:  []string{"prod-us", "dev"},
+		},
+		{
+			name:  "exact names missing from the kubeconfig are kept",
+			specs: contextSpecs([]string{"in-cluster"}),
+			want:  []string{"in-cluster"},
+		},
+		{
+			name:  "globs match sorted kubeconfig contexts",
+			specs: contextSpecs([]string{"prod-*"}),
+			want:  []string{"prod-eu", "prod-eu-canary", "prod-us"},
+		},
+		{
+			name:  "regular expressions",
+			specs: contextSpecs([]string{"/^prod-(eu|us)$/"}),
+			want:  []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:    "excludes drop matches",
+			specs:   contextSpecs([]string{"prod-*"}),
+			exclude: []string{"*-canary"},
+			want:    []string{"prod-eu", "prod-us"},
+		},
+		{
+			name:    "excludes alone apply to every context",
+			exclude: []string{"prod-*", "arn:*"},
+			want:    []string{"dev"},
+		},
+		{
+			name:  "globs match EKS ARNs",
+			specs: contextSpecs([]string{"arn:aws:eks:*"}),
+			want:  []string{eksDev, eksProd},
+		},
+		{
+			name:    "every context includes EKS ARNs",
+			specs:   contextSpecs([]string{"*"}),
+			exclude: []string{"*/dev", "prod-*"},
+			want:    []string{eksProd, "dev"},
+		},
+		{
+			name:    "excludes apply to exact names",
+			specs:   contextSpecs([]string{"dev", "prod-eu"}),
+			exclude: []string{"dev"},
+			want:    []string{"prod-eu"},
+		},
+		{
+			name:  "a context matched twice is listed once",
+			specs: contextSpecs([]string{"prod-eu", "prod-*"}),
+			want:  []string{"prod-eu", "prod-eu-canary", "prod-us"},
+		},
+		{
+			name: "later entries override earlier ones",
+			specs: []ContextSpec{
+				{Name: "*", Tags: map[string]string{"env": "any", "owner": "platform"}},
+				{Name: "prod-*", Tags: map[string]string{"env": "prod"}},
+				{Name: "prod-eu", Tags: map[string]string{"region": "eu"}},
+			},
+			want: []string{eksDev, eksProd, "dev", "prod-eu", "prod-eu-canary", "prod-us"},
+			tags: map[string]map[string]string{
+				"dev":     {"env": "any", "owner": "platform"},
+				"prod-eu": {"env": "prod", "owner": "platform", "region": "eu"},
+				"prod-us": {"env": "prod", "owner": "platform"},
+			},
+		},
+		{
+			name:    "a pattern matching nothing fails",
+			specs:   contextSpecs([]string{"staging-*"}),
+			wantErr: "no kube contexts match",
+		},
+		{
+			name:    "everything excluded fails",
+			specs:   contextSpecs([]string{"dev"}),
+			exclude: []string{"*"},
+			wantErr: "no kube contexts match",
+		},
+		{
+			name:    "invalid patterns fail",
+			specs:   contextSpecs([]string{"/(/"}),
+			wantErr: "invalid context pattern",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets, err := resolveContexts(kubeconfig, tt.specs, tt.exclude)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveContexts() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, target := range targets {
+				names = append(names, target.name)
+				if want, ok := tt.tags[target.name]; ok && !reflect.DeepEqual(target.override.Tags, want) {
+					t.Errorf("tags of %s = %v, want %v", target.name, target.override.Tags, want)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("contexts = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+// writeKubeconfig writes a kubeconfig defining contexts and returns it.
+func writeKubeconfig(t *testing.T, contexts ...string) internal.Kubeconfig {
+	t.Helper()
+	config := clientcmdapi.NewConfig()
+	config.Clusters["cluster"] = &clientcmdapi.Cluster{Server: "https://127.0.0.1:6443"}
+	config.AuthInfos["user"] = &clientcmdapi.AuthInfo{}
+	for _, name := range contexts {
+		config.Contexts[name] = &clientcmdapi.Context{Cluster: "cluster", AuthInfo: "user"}
+	}
+	path := filepath.Join(t.TempDir(), "config")
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		t.Fatal(err)
+	}
+	return internal.Kubeconfig{Paths: []string{path}}
+}
@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 )
@@ -15,25 +16,25 @@ func fetchCustomResources(
 	parent *schema.Resource,
 	res chan<- interface{},
 ) error {
-	client := meta.(*internal.Client).ApiextensionsClientset
+	c := meta.(*internal.Client)
+	client := c.ApiextensionsClientset
 
-	// Get all CustomResourceDefinitions
-	crds, err := client.ApiextensionsV1().CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
+	// Fetch all CustomResourceDefinitions, or only their changes when incremental
+	return fetchResource(ctx, c, "crds", c.ListOptions("crds"), client.ApiextensionsV1().CustomResourceDefinitions().List, client.ApiextensionsV1().CustomResourceDefinitions().Watch, func(obj runtime.Object) {
+		res <- crdRow(c, obj.(*apiextensionsv1.CustomResourceDefinition))
+	})
+}
 
-	for _, crd := range crds.Items {
-		res <- map[string]interface{}{
-			"id":         string(crd.UID),
-			"name":       crd.Name,
-			"group":      crd.Spec.Group,
-			"kind":       crd.Spec.Names.Kind,
-			"plural":     crd.Spec.Names.Plural,
-			"scope":      string(crd.Spec.Scope),
-			"created_at": crd.CreationTimestamp.Time,
-		}
+func crdRow(c *internal.Client, crd *apiextensionsv1.CustomResourceDefinition) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster_uid":  c.ClusterUID,
+		"context_name": c.Context(),
+		"uid":          string(crd.UID),
+		"name":         crd.Name,
+		"group_name":   crd.Spec.Group,
+		"kind":         crd.Spec.Names.Kind,
+		"plural":       crd.Spec.Names.Plural,
+		"scope":        string(crd.Spec.Scope),
+		"created_at":   crd.CreationTimestamp.Time,
 	}
-
-	return nil
 }
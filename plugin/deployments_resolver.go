@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 )
@@ -15,23 +16,26 @@ func fetchDeployments(
 	parent *schema.Resource,
 	res chan<- interface{},
 ) error {
-	client := meta.(*internal.Client).Clientset
+	c := meta.(*internal.Client)
+	client := c.Clientset
 
-	deployments, err := client.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
+	return fetchNamespaced(ctx, c, "deployments", func(namespace string) (internal.ListFunc[*appsv1.DeploymentList], internal.WatchFunc) {
+		deployments := client.AppsV1().Deployments(namespace)
+		return deployments.List, deployments.Watch
+	}, func(obj runtime.Object) {
+		res <- deploymentRow(c, obj.(*appsv1.Deployment))
+	})
+}
 
-	for _, deployment := range deployments.Items {
-		res <- map[string]interface{}{
-			"id":         string(deployment.UID),
-			"name":       deployment.Name,
-			"namespace":  deployment.Namespace,
-			"replicas":   deployment.Status.Replicas,
-			"ready":      deployment.Status.ReadyReplicas,
-			"created_at": deployment.CreationTimestamp.Time,
-		}
+func deploymentRow(c *internal.Client, deployment *appsv1.Deployment) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster_uid":  c.ClusterUID,
+		"context_name": c.Context(),
+		"uid":          string(deployment.UID),
+		"name":         deployment.Name,
+		"namespace":    deployment.Namespace,
+		"replicas":     deployment.Status.Replicas,
+		"ready":        deployment.Status.ReadyReplicas,
+		"created_at":   deployment.CreationTimestamp.Time,
 	}
-
-	return nil
 }
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudquery/plugin-sdk/v4/message"
+	"github.com/cloudquery/plugin-sdk/v4/plugin"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/state"
+	"github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const defaultIncrementalWindow = "10s"
+
+// fetchResource emits every object of one resource for a context. With
+// incremental sync enabled and a saved bookmark only the changes since that
+// bookmark are fetched; otherwise, or when the bookmark has expired, the
+// resource is paged through with a full list.
+func fetchResource[L runtime.Object](ctx context.Context, c *internal.Client, resource string, opts metav1.ListOptions, list internal.ListFunc[L], watchFn internal.WatchFunc, emit func(obj runtime.Object)) error {
+	resource = internal.SelectorKey(resource, opts)
+	if c.Versions != nil {
+		done, err := fetchChanges(ctx, c, resource, opts, watchFn, emit)
+		if done || err != nil {
+			return err
+		}
+	}
+
+	var resourceVersion string
+	err := internal.ListPages(ctx, opts, c.PageSize, list, func(page L) error {
+		items, err := meta.ExtractList(page)
+		if err != nil {
+			return fmt.Errorf("extract list items: %w", err)
+		}
+		for _, item := range items {
+			emit(item)
+		}
+		listMeta, err := meta.ListAccessor(page)
+		if err != nil {
+			return fmt.Errorf("read list metadata: %w", err)
+		}
+		resourceVersion = listMeta.GetResourceVersion()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if c.Versions != nil {
+		c.Versions.Observe(resource, resourceVersion, false)
+	}
+	return nil
+}
+
+// fetchNamespaced emits the objects of a namespaced resource in the
+// namespaces the context is limited to: one list per namespace when the
+// scope names them, otherwise a cluster-wide list filtered by namespace.
+// Each namespace keeps its own bookmark.
+func fetchNamespaced[L runtime.Object](ctx context.Context, c *internal.Client, resource string, client func(namespace string) (internal.ListFunc[L], internal.WatchFunc), emit func(obj runtime.Object)) error {
+	scope, err := c.NamespaceScope(ctx)
+	if err != nil {
+		return err
+	}
+	opts := c.ListOptions(resource)
+	if scope == nil || scope.Namespaces == nil {
+		list, watchFn := client(metav1.NamespaceAll)
+		return fetchResource(ctx, c, resource, opts, list, watchFn, func(obj runtime.Object) {
+			if accessor, err := meta.Accessor(obj); err == nil && scope.Allows(accessor.GetNamespace()) {
+				emit(obj)
+			}
+		})
+	}
+	for _, namespace := range scope.Namespaces {
+		list, watchFn := client(namespace)
+		if err := fetchResource(ctx, c, internal.NamespacedKey(resource, namespace), opts, list, watchFn, emit); err != nil {
+			return fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// fetchChanges watches resource from its saved bookmark for the incremental
+// window, emitting added and modified objects and recording deleted ones for
+// commitVersions to remove. It reports false when there is no usable bookmark
+// and a full list is needed instead.
+func fetchChanges(ctx context.Context, c *internal.Client, resource string, opts metav1.ListOptions, watchFn internal.WatchFunc, emit func(obj runtime.Object)) (bool, error) {
+	logger := zerolog.Ctx(ctx).With().Str("context", c.Context()).Str("resource", resource).Logger()
+
+	since, err := c.Versions.Get(ctx, resource)
+	if err != nil {
+		return false, fmt.Errorf("read resourceVersion: %w", err)
+	}
+	if since == "" {
+		return false, nil
+	}
+
+	timeout := int64(c.Versions.Window / time.Second)
+	if timeout < 1 {
+		timeout = 1
+	}
+	opts.ResourceVersion = since
+	opts.AllowWatchBookmarks = true
+	opts.TimeoutSeconds = &timeout
+	w, err := watchFn(ctx, opts)
+	if err != nil {
+		if isExpired(err) {
+			logger.Info().Str("resource_version", since).Msg("resourceVersion expired, falling back to a full list")
+			return false, nil
+		}
+		return false, err
+	}
+	defer w.Stop()
+
+	last := since
+	changes := 0
+	for {
+		var event watch.Event
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				logger.Debug().Str("resource_version", last).Int("changes", changes).Msg("fetched changes since bookmark")
+				c.Versions.Observe(resource, last, true)
+				return true, nil
+			}
+			event = e
+		}
+
+		switch event.Type {
+		case watch.Error:
+			err := apierrors.FromObject(event.Object)
+			if isExpired(err) {
+				logger.Info().Str("resource_version", since).Msg("resourceVersion expired, falling back to a full list")
+				return false, nil
+			}
+			return false, err
+		case watch.Added, watch.Modified:
+			emit(event.Object)
+			changes++
+		case watch.Deleted:
+			accessor, err := meta.Accessor(event.Object)
+			if err != nil {
+				return false, err
+			}
+			c.Versions.Delete(resource, string(accessor.GetUID()))
+			changes++
+		}
+
+		if accessor, err := meta.Accessor(event.Object); err == nil && accessor.GetResourceVersion() != "" {
+			last = accessor.GetResourceVersion()
+		}
+	}
+}
+
+func isExpired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
+
+// commitVersions removes the objects the watches reported as deleted, from
+// the store and the destinations, and saves the bookmarks of every table
+// whose list and writes all succeeded, so a failed table is fetched from its
+// old bookmark again.
+func (c *SourceClient) commitVersions(ctx context.Context, run *syncRun, client *internal.Client, tables schema.Tables, res chan<- message.SyncMessage) {
+	if client.Versions == nil {
+		return
+	}
+	for _, table := range tables {
+		if !run.complete(client.ID(), table.Name) {
+			continue
+		}
+		logger := c.logger.With().Str("context", client.Context()).Str("table", table.Name).Logger()
+		resource := tableResources[table.Name]
+		deleted := client.Versions.Deleted(resource)
+		if err := c.removeDeleted(ctx, client, table.Name, deleted); err != nil {
+			logger.Warn().Err(err).Msg("failed to remove deleted objects")
+			continue
+		}
+		c.emitDeletes(table, client.ClusterUID, deleted, res)
+		if client.Store != nil {
+			run.removed(client, table.Name, int64(len(deleted)))
+		}
+		if err := client.Versions.Commit(ctx, resource); err != nil {
+			logger.Warn().Err(err).Msg("failed to save resourceVersion")
+		}
+	}
+}
+
+// emitDeletes sends destinations a delete for each object of table. Only
+// incremental tables need them; the others are cleared of objects a sync did
+// not see by the overwrite-delete-stale write mode.
+func (c *SourceClient) emitDeletes(table *schema.Table, clusterUID string, uids []string, res chan<- message.SyncMessage) {
+	if !table.IsIncremental {
+		return
+	}
+	for _, uid := range uids {
+		msg, err := deleteRecord(table, clusterUID, uid)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("table", table.Name).Str("uid", uid).Msg("failed to build delete")
+			continue
+		}
+		res <- msg
+	}
+}
+
+// deleteRecord matches the row of one object by its primary key.
+func deleteRecord(table *schema.Table, clusterUID, uid string) (*message.SyncDeleteRecord, error) {
+	keys := []struct {
+		column string
+		value  string
+	}{{"cluster_uid", clusterUID}, {"uid", uid}}
+
+	predicates := make(message.Predicates, 0, len(keys))
+	for _, key := range keys {
+		column := table.Columns.Get(key.column)
+		if column == nil {
+			return nil, fmt.Errorf("table %s has no %s column", table.Name, key.column)
+		}
+		keyTable := &schema.Table{Name: table.Name, Columns: schema.ColumnList{*column}}
+		resource := schema.NewResourceData(keyTable, nil, nil)
+		if err := resource.Set(key.column, key.value); err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, message.Predicate{
+			Operator: "eq",
+			Column:   key.column,
+			Record:   resource.GetValues().ToArrowRecord(keyTable.ToArrowSchema()),
+		})
+	}
+	return &message.SyncDeleteRecord{DeleteRecord: message.DeleteRecord{
+		TableName:   table.Name,
+		WhereClause: message.PredicateGroups{{GroupingType: "AND", Predicates: predicates}},
+	}}, nil
+}
+
+func (c *SourceClient) removeDeleted(ctx context.Context, client *internal.Client, table string, uids []string) error {
+	if client.Store == nil {
+		return nil
+	}
+	for _, uid := range uids {
+		if err := client.Store.RemoveObject(ctx, table, client.ClusterUID, uid, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionBackend returns the CloudQuery state backend when one is configured.
+// Otherwise it returns nil and bookmarks are kept in k8s_resource_versions.
+func (c *SourceClient) versionBackend(ctx context.Context, options plugin.SyncOptions) (internal.VersionBackend, func(), error) {
+	if options.BackendOptions != nil && options.BackendOptions.TableName != "" {
+		stateClient, err := state.NewConnectedClient(ctx, options.BackendOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to state backend: %w", err)
+		}
+		return stateVersions{client: stateClient}, func() {
+			if err := stateClient.Flush(ctx); err != nil {
+				c.logger.Warn().Err(err).Msg("failed to flush state backend")
+			}
+			if err := stateClient.Close(); err != nil {
+				c.logger.Warn().Err(err).Msg("failed to close state backend")
+			}
+		}, nil
+	}
+	return nil, func() {}, nil
+}
+
+// stateVersions keeps bookmarks in the CloudQuery state backend.
+type stateVersions struct {
+	client state.Client
+}
+
+func (s stateVersions) ResourceVersion(ctx context.Context, clusterUID, resource string) (string, error) {
+	return s.client.GetKey(ctx, stateKey(clusterUID, resource))
+}
+
+func (s stateVersions) SaveResourceVersion(ctx context.Context, clusterUID, resource, resourceVersion string) error {
+	return s.client.SetKey(ctx, stateKey(clusterUID, resource), resourceVersion)
+}
+
+func stateKey(clusterUID, resource string) string {
+	return "k8s-custom:" + clusterUID + ":" + resource
+}
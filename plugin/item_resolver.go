@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+// resolveItemValues copies the column values that table resolvers push as
+// map[string]interface{} items into the resource.
+func resolveItemValues(ctx context.Context, meta schema.ClientMeta, resource *schema.Resource) error {
+	item, ok := resource.GetItem().(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for _, column := range resource.Table.Columns {
+		value, ok := item[column.Name]
+		if !ok {
+			continue
+		}
+		if err := resource.Set(column.Name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeResource writes the resolved resource to Postgres when the direct
+// store is enabled.
+func storeResource(ctx context.Context, meta schema.ClientMeta, resource *schema.Resource) error {
+	client := meta.(*internal.Client)
+	if client.Writer != nil {
+		return client.Writer.Add(ctx, resource)
+	}
+	if client.Store == nil {
+		return nil
+	}
+	return client.Store.UpsertResource(ctx, resource)
+}
+
+// resolveRow builds a resource for table from a row pushed outside the
+// scheduler, running the same pre-resource and column resolvers it would.
+func resolveRow(ctx context.Context, client *internal.Client, table *schema.Table, row map[string]interface{}) (*schema.Resource, error) {
+	resource := schema.NewResourceData(table, nil, row)
+	if table.PreResourceResolver != nil {
+		if err := table.PreResourceResolver(ctx, client, resource); err != nil {
+			return nil, err
+		}
+	}
+	for _, column := range table.Columns {
+		if column.Resolver == nil {
+			continue
+		}
+		if err := column.Resolver(ctx, client, resource, column); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", table.Name, column.Name, err)
+		}
+	}
+	return resource, nil
+}
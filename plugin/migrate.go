@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+// Migrate applies the pending schema migrations to database_url without
+// syncing anything. Plugin starts run the same migrations automatically.
+func Migrate(ctx context.Context, logger zerolog.Logger, spec any) error {
+	store, err := openStore(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	applied, err := store.Migrate(ctx)
+	for _, migration := range applied {
+		logger.Info().Int64("version", migration.Version).Str("name", migration.Name).Msg("applied migration")
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		logger.Info().Msg("schema is up to date")
+	}
+	return nil
+}
+
+// PrintMigrations writes every known migration and when it was applied to w.
+func PrintMigrations(ctx context.Context, spec any, w io.Writer) error {
+	store, err := openStore(ctx, spec)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	statuses, err := store.MigrationStatuses(ctx)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%4d  %-30s  %s\n", status.Version, status.Name, applied)
+	}
+	return nil
+}
+
+func openStore(ctx context.Context, spec any) (*internal.Store, error) {
+	cfg, err := loadConfig(spec)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DatabaseURL == "" {
+		return nil, errors.New("migrations require database_url or DATABASE_URL")
+	}
+	return internal.NewStore(ctx, cfg.DatabaseURL)
+}
@@ -8,27 +8,41 @@ import (
 
 func NamespacesTable() *schema.Table {
 	return &schema.Table{
-		Name:        "k8s_namespaces",
-		Description: "Kubernetes namespaces",
-		Resolver:    fetchNamespaces,
-		Columns: []schema.Column{
+		Name:                 "k8s_namespaces",
+		Description:          "Kubernetes namespaces",
+		Resolver:             fetchNamespaces,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
 			{
-				Name:       "id",
-				Type:       types.ExtensionTypes.UUID,
+				Name:       "cluster_uid",
+				Type:       arrow.BinaryTypes.String,
 				PrimaryKey: true,
 			},
 			{
-				Name: "name",
+				Name: "context_name",
 				Type: arrow.BinaryTypes.String,
 			},
 			{
-				Name: "status",
-				Type: arrow.BinaryTypes.String,
+				Name:       "uid",
+				Type:       types.ExtensionTypes.UUID,
+				PrimaryKey: true,
+			},
+			{
+				Name:    "name",
+				Type:    arrow.BinaryTypes.String,
+				NotNull: true,
+			},
+			{
+				Name:    "status",
+				Type:    arrow.BinaryTypes.String,
+				NotNull: true,
 			},
 			{
-				Name: "created_at",
-				Type: arrow.FixedWidthTypes.Timestamp_ns,
+				Name:    "created_at",
+				Type:    arrow.FixedWidthTypes.Timestamp_ns,
+				NotNull: true,
 			},
-		},
+		}, syncColumns()...),
 	}
 }
@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 )
@@ -16,21 +17,27 @@ func fetchNamespaces(
 	res chan<- interface{},
 ) error {
 
-	client := meta.(*internal.Client).Clientset
+	c := meta.(*internal.Client)
+	client := c.Clientset
 
-	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	scope, err := c.NamespaceScope(ctx)
 	if err != nil {
 		return err
 	}
-
-	for _, ns := range namespaces.Items {
-		res <- map[string]interface{}{
-			"id":         string(ns.UID),
-			"name":       ns.Name,
-			"status":     string(ns.Status.Phase),
-			"created_at": ns.CreationTimestamp.Time,
+	return fetchResource(ctx, c, "namespaces", c.ListOptions("namespaces"), client.CoreV1().Namespaces().List, client.CoreV1().Namespaces().Watch, func(obj runtime.Object) {
+		if namespace := obj.(*corev1.Namespace); scope.AllowsNamespace(namespace) {
+			res <- namespaceRow(c, namespace)
 		}
-	}
+	})
+}
 
-	return nil
+func namespaceRow(c *internal.Client, ns *corev1.Namespace) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster_uid":  c.ClusterUID,
+		"context_name": c.Context(),
+		"uid":          string(ns.UID),
+		"name":         ns.Name,
+		"status":       string(ns.Status.Phase),
+		"created_at":   ns.CreationTimestamp.Time,
+	}
 }
@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const (
+	nodeRolePrefix = "node-role.kubernetes.io/"
+	// legacyNodeRoleLabel is set by older installers instead of a
+	// node-role.kubernetes.io/<role> label.
+	legacyNodeRoleLabel = "kubernetes.io/role"
+)
+
+func fetchNodes(
+	ctx context.Context,
+	meta schema.ClientMeta,
+	parent *schema.Resource,
+	res chan<- interface{},
+) error {
+	c := meta.(*internal.Client)
+	client := c.Clientset
+
+	return fetchResource(ctx, c, "nodes", c.ListOptions("nodes"), client.CoreV1().Nodes().List, client.CoreV1().Nodes().Watch, func(obj runtime.Object) {
+		res <- nodeRow(c, obj.(*corev1.Node))
+	})
+}
+
+func nodeRow(c *internal.Client, node *corev1.Node) map[string]interface{} {
+	info := node.Status.NodeInfo
+	return map[string]interface{}{
+		"cluster_uid":                         c.ClusterUID,
+		"context_name":                        c.Context(),
+		"uid":                                 string(node.UID),
+		"name":                                node.Name,
+		"roles":                               nodeRoles(node.Labels),
+		"provider_id":                         node.Spec.ProviderID,
+		"addresses":                           nodeAddresses(node.Status.Addresses),
+		"capacity_cpu_millicores":             milliQuantity(node.Status.Capacity, corev1.ResourceCPU),
+		"capacity_memory_bytes":               quantity(node.Status.Capacity, corev1.ResourceMemory),
+		"capacity_pods":                       quantity(node.Status.Capacity, corev1.ResourcePods),
+		"capacity_ephemeral_storage_bytes":    quantity(node.Status.Capacity, corev1.ResourceEphemeralStorage),
+		"allocatable_cpu_millicores":          milliQuantity(node.Status.Allocatable, corev1.ResourceCPU),
+		"allocatable_memory_bytes":            quantity(node.Status.Allocatable, corev1.ResourceMemory),
+		"allocatable_pods":                    quantity(node.Status.Allocatable, corev1.ResourcePods),
+		"allocatable_ephemeral_storage_bytes": quantity(node.Status.Allocatable, corev1.ResourceEphemeralStorage),
+		"conditions":                          nodeConditions(node.Status.Conditions),
+		"taints":                              nodeTaints(node.Spec.Taints),
+		"unschedulable":                       node.Spec.Unschedulable,
+		"kubelet_version":                     info.KubeletVersion,
+		"os_image":                            info.OSImage,
+		"operating_system":                    info.OperatingSystem,
+		"kernel_version":                      info.KernelVersion,
+		"container_runtime_version":           info.ContainerRuntimeVersion,
+		"architecture":                        info.Architecture,
+		"labels":                              node.Labels,
+		"created_at":                          node.CreationTimestamp.Time,
+	}
+}
+
+// nodeRoles reads the roles from node-role.kubernetes.io/<role> labels and
+// the legacy kubernetes.io/role label, sorted and without duplicates.
+func nodeRoles(labels map[string]string) []string {
+	roles := map[string]struct{}{}
+	for key, value := range labels {
+		if role, ok := strings.CutPrefix(key, nodeRolePrefix); ok && role != "" {
+			roles[role] = struct{}{}
+		} else if key == legacyNodeRoleLabel && value != "" {
+			roles[value] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(roles))
+	for role := range roles {
+		sorted = append(sorted, role)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func nodeAddresses(addresses []corev1.NodeAddress) []map[string]string {
+	rows := make([]map[string]string, 0, len(addresses))
+	for _, address := range addresses {
+		rows = append(rows, map[string]string{
+			"type":    string(address.Type),
+			"address": address.Address,
+		})
+	}
+	return rows
+}
+
+// nodeConditions leaves out lastHeartbeatTime: it changes on every kubelet
+// report and would make every sync a new version in history.
+func nodeConditions(conditions []corev1.NodeCondition) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(conditions))
+	for _, condition := range conditions {
+		row := map[string]interface{}{
+			"type":                 string(condition.Type),
+			"status":               string(condition.Status),
+			"reason":               condition.Reason,
+			"message":              condition.Message,
+			"last_transition_time": nil,
+		}
+		if !condition.LastTransitionTime.IsZero() {
+			row["last_transition_time"] = condition.LastTransitionTime.UTC().Format(time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func nodeTaints(taints []corev1.Taint) []map[string]string {
+	rows := make([]map[string]string, 0, len(taints))
+	for _, taint := range taints {
+		rows = append(rows, map[string]string{
+			"key":    taint.Key,
+			"value":  taint.Value,
+			"effect": string(taint.Effect),
+		})
+	}
+	return rows
+}
+
+// quantity returns the value of a resource in its base unit (bytes, pods),
+// or nil when the node does not report it.
+func quantity(resources corev1.ResourceList, name corev1.ResourceName) interface{} {
+	value, ok := resources[name]
+	if !ok {
+		return nil
+	}
+	return value.Value()
+}
+
+// milliQuantity returns the value of a resource in thousandths, e.g. CPU in
+// millicores, or nil when the node does not report it.
+func milliQuantity(resources corev1.ResourceList, name corev1.ResourceName) interface{} {
+	value, ok := resources[name]
+	if !ok {
+		return nil
+	}
+	return value.MilliValue()
+}
@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 )
@@ -15,22 +16,25 @@ func fetchPods(
 	parent *schema.Resource,
 	res chan<- interface{},
 ) error {
-	client := meta.(*internal.Client).Clientset
+	c := meta.(*internal.Client)
+	client := c.Clientset
 
-	pods, err := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
+	return fetchNamespaced(ctx, c, "pods", func(namespace string) (internal.ListFunc[*corev1.PodList], internal.WatchFunc) {
+		pods := client.CoreV1().Pods(namespace)
+		return pods.List, pods.Watch
+	}, func(obj runtime.Object) {
+		res <- podRow(c, obj.(*corev1.Pod))
+	})
+}
 
-	for _, pod := range pods.Items {
-		res <- map[string]interface{}{
-			"id":         string(pod.UID),
-			"name":       pod.Name,
-			"namespace":  pod.Namespace,
-			"status":     string(pod.Status.Phase),
-			"created_at": pod.CreationTimestamp.Time,
-		}
+func podRow(c *internal.Client, pod *corev1.Pod) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster_uid":  c.ClusterUID,
+		"context_name": c.Context(),
+		"uid":          string(pod.UID),
+		"name":         pod.Name,
+		"namespace":    pod.Namespace,
+		"status":       string(pod.Status.Phase),
+		"created_at":   pod.CreationTimestamp.Time,
 	}
-
-	return nil
 }
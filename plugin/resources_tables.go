@@ -6,90 +6,144 @@ import (
 	"github.com/cloudquery/plugin-sdk/v4/types"
 )
 
+// These tables are the single schema definition for both the Arrow records
+// sent to destinations and the Postgres DDL generated by internal.Store.
+
 func ClustersTable() *schema.Table {
 	return &schema.Table{
-		Name: "k8s_clusters",
+		Name:                 "k8s_clusters",
+		Resolver:             fetchClusters,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
 		Columns: []schema.Column{
 			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
+			{Name: "uid_source", Type: arrow.BinaryTypes.String},
 			{Name: "context_name", Type: arrow.BinaryTypes.String},
-			{Name: "cluster_name", Type: arrow.BinaryTypes.String},
+			{Name: "cluster_name", Type: arrow.BinaryTypes.String, NotNull: true},
 			{Name: "server", Type: arrow.BinaryTypes.String},
 			{Name: "ca_file", Type: arrow.BinaryTypes.String},
 			{Name: "insecure_skip_verify", Type: arrow.FixedWidthTypes.Boolean},
 			{Name: "namespace", Type: arrow.BinaryTypes.String},
 			{Name: "kubernetes_version", Type: arrow.BinaryTypes.String},
 			{Name: "node_count", Type: arrow.PrimitiveTypes.Int64},
-			{Name: "synced_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-			{Name: "updated_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
+			{Name: "tags", Type: types.ExtensionTypes.JSON},
+			{Name: "sync_filters", Type: types.ExtensionTypes.JSON},
+			{Name: "synced_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "updated_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
 		},
 	}
 }
 
+func NodesTable() *schema.Table {
+	return &schema.Table{
+		Name:                 "k8s_nodes",
+		Resolver:             fetchNodes,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
+			{Name: "context_name", Type: arrow.BinaryTypes.String},
+			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "roles", Type: types.ExtensionTypes.JSON},
+			{Name: "provider_id", Type: arrow.BinaryTypes.String},
+			{Name: "addresses", Type: types.ExtensionTypes.JSON},
+			{Name: "capacity_cpu_millicores", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "capacity_memory_bytes", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "capacity_pods", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "capacity_ephemeral_storage_bytes", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "allocatable_cpu_millicores", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "allocatable_memory_bytes", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "allocatable_pods", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "allocatable_ephemeral_storage_bytes", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "conditions", Type: types.ExtensionTypes.JSON},
+			{Name: "taints", Type: types.ExtensionTypes.JSON},
+			{Name: "unschedulable", Type: arrow.FixedWidthTypes.Boolean, NotNull: true},
+			{Name: "kubelet_version", Type: arrow.BinaryTypes.String},
+			{Name: "os_image", Type: arrow.BinaryTypes.String},
+			{Name: "operating_system", Type: arrow.BinaryTypes.String},
+			{Name: "kernel_version", Type: arrow.BinaryTypes.String},
+			{Name: "container_runtime_version", Type: arrow.BinaryTypes.String},
+			{Name: "architecture", Type: arrow.BinaryTypes.String},
+			{Name: "labels", Type: types.ExtensionTypes.JSON},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		}, syncColumns()...),
+	}
+}
+
 func PodsTable() *schema.Table {
 	return &schema.Table{
-		Name:     "k8s_pods",
-		Resolver: fetchPods,
-		Columns: []schema.Column{
-			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+		Name:                 "k8s_pods",
+		Resolver:             fetchPods,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
 			{Name: "context_name", Type: arrow.BinaryTypes.String},
 			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
-			{Name: "namespace", Type: arrow.BinaryTypes.String},
-			{Name: "name", Type: arrow.BinaryTypes.String},
-			{Name: "status", Type: arrow.BinaryTypes.String},
-			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-		},
+			{Name: "namespace", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "status", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		}, syncColumns()...),
 	}
 }
 
 func DeploymentsTable() *schema.Table {
 	return &schema.Table{
-		Name:     "k8s_deployments",
-		Resolver: fetchDeployments,
-		Columns: []schema.Column{
-			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+		Name:                 "k8s_deployments",
+		Resolver:             fetchDeployments,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
 			{Name: "context_name", Type: arrow.BinaryTypes.String},
 			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
-			{Name: "namespace", Type: arrow.BinaryTypes.String},
-			{Name: "name", Type: arrow.BinaryTypes.String},
-			{Name: "replicas", Type: arrow.PrimitiveTypes.Int64},
-			{Name: "ready", Type: arrow.PrimitiveTypes.Int64},
-			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-		},
+			{Name: "namespace", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "replicas", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "ready", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		}, syncColumns()...),
 	}
 }
 
 func ServicesTable() *schema.Table {
 	return &schema.Table{
-		Name:     "k8s_services",
-		Resolver: fetchServices,
-		Columns: []schema.Column{
-			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+		Name:                 "k8s_services",
+		Resolver:             fetchServices,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
 			{Name: "context_name", Type: arrow.BinaryTypes.String},
 			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
-			{Name: "namespace", Type: arrow.BinaryTypes.String},
-			{Name: "name", Type: arrow.BinaryTypes.String},
-			{Name: "type", Type: arrow.BinaryTypes.String},
-			{Name: "cluster_ip", Type: arrow.BinaryTypes.String},
-			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-		},
+			{Name: "namespace", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "type", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "cluster_ip", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		}, syncColumns()...),
 	}
 }
 
 func CustomResourcesTable() *schema.Table {
 	return &schema.Table{
-		Name:     "k8s_custom_resources",
-		Resolver: fetchCustomResources,
-		Columns: []schema.Column{
-			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+		Name:                 "k8s_custom_resources",
+		Resolver:             fetchCustomResources,
+		PreResourceResolver:  resolveItemValues,
+		PostResourceResolver: storeResource,
+		Columns: append([]schema.Column{
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String, PrimaryKey: true},
 			{Name: "context_name", Type: arrow.BinaryTypes.String},
 			{Name: "uid", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
-			{Name: "name", Type: arrow.BinaryTypes.String},
-			{Name: "group_name", Type: arrow.BinaryTypes.String},
-			{Name: "kind", Type: arrow.BinaryTypes.String},
-			{Name: "plural", Type: arrow.BinaryTypes.String},
-			{Name: "scope", Type: arrow.BinaryTypes.String},
-			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
-		},
+			{Name: "name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "group_name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "kind", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "plural", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "scope", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "created_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		}, syncColumns()...),
 	}
 }
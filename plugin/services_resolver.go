@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 )
@@ -15,23 +16,26 @@ func fetchServices(
 	parent *schema.Resource,
 	res chan<- interface{},
 ) error {
-	client := meta.(*internal.Client).Clientset
+	c := meta.(*internal.Client)
+	client := c.Clientset
 
-	services, err := client.CoreV1().Services("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
-	}
+	return fetchNamespaced(ctx, c, "services", func(namespace string) (internal.ListFunc[*corev1.ServiceList], internal.WatchFunc) {
+		services := client.CoreV1().Services(namespace)
+		return services.List, services.Watch
+	}, func(obj runtime.Object) {
+		res <- serviceRow(c, obj.(*corev1.Service))
+	})
+}
 
-	for _, service := range services.Items {
-		res <- map[string]interface{}{
-			"id":         string(service.UID),
-			"name":       service.Name,
-			"namespace":  service.Namespace,
-			"type":       string(service.Spec.Type),
-			"cluster_ip": service.Spec.ClusterIP,
-			"created_at": service.CreationTimestamp.Time,
-		}
+func serviceRow(c *internal.Client, service *corev1.Service) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster_uid":  c.ClusterUID,
+		"context_name": c.Context(),
+		"uid":          string(service.UID),
+		"name":         service.Name,
+		"namespace":    service.Namespace,
+		"type":         string(service.Spec.Type),
+		"cluster_ip":   service.Spec.ClusterIP,
+		"created_at":   service.CreationTimestamp.Time,
 	}
-
-	return nil
 }
@@ -4,39 +4,143 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Genos0820/cq-k8s-custom/internal"
 	"github.com/cloudquery/plugin-sdk/v4/message"
 	"github.com/cloudquery/plugin-sdk/v4/plugin"
+	"github.com/cloudquery/plugin-sdk/v4/scheduler"
 	"github.com/cloudquery/plugin-sdk/v4/schema"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// generateClusterUID creates a unique identifier for a cluster based on its API server address.
-func generateClusterUID(client *internal.Client) string {
-	if client != nil && client.Config != nil && client.Config.Host != "" {
-		// Use a UUID5 hash of the API server address for uniqueness
-		return uuid.NewSHA1(uuid.NameSpaceURL, []byte(client.Config.Host)).String()
-	}
-	// Fallback: random UUID
-	return uuid.New().String()
+const (
+	defaultMaxConcurrentContexts = 4
+	defaultContextTimeout        = "30m"
+	defaultResyncPeriod          = "10m"
+	defaultRequestTimeout        = "1m"
+)
+
+// tableResources maps each table to the resource name used in Config.Resources.
+var tableResources = map[string]string{
+	"k8s_clusters":         "clusters",
+	"k8s_namespaces":       "namespaces",
+	"k8s_nodes":            "nodes",
+	"k8s_pods":             "pods",
+	"k8s_deployments":      "deployments",
+	"k8s_services":         "services",
+	"k8s_custom_resources": "crds",
 }
 
 type Config struct {
-	DatabaseURL string   `json:"database_url"`
-	Contexts    []string `json:"contexts"`
-	Resources   []string `json:"resources"`
+	// DatabaseURL enables writing directly to Postgres in addition to the
+	// CloudQuery destinations. Leave empty to rely on destinations only.
+	DatabaseURL string `json:"database_url"`
+	// Kubeconfig is a kubeconfig path or a list of them; directories load
+	// every file inside. Defaults to KUBECONFIG, then ~/.kube/config.
+	Kubeconfig stringList `json:"kubeconfig"`
+	// Contexts selects kube contexts by exact name, glob ("prod-*", "*" for
+	// all) or regular expression in slashes ("/^prod-(eu|us)$/"). Empty
+	// means the current context. An entry may also be an object overriding
+	// settings for the contexts it matches; see ContextSpec.
+	Contexts []ContextSpec `json:"contexts"`
+	// ExcludeContexts drops contexts matching any of these patterns.
+	ExcludeContexts []string `json:"exclude_contexts"`
+	Resources       []string `json:"resources"`
+	// Tags are stored with every k8s_clusters row, e.g. {"team": "infra"}.
+	Tags map[string]string `json:"tags"`
+	// Namespaces limits namespaced resources to the namespaces it includes
+	// by name, glob or label selector, minus the ones it excludes.
+	Namespaces internal.NamespaceFilter `json:"namespaces"`
+	// Impersonate makes every request as this user, groups and extra
+	// instead of the identity of the kubeconfig or service account.
+	Impersonate internal.Impersonation `json:"impersonate"`
+	// Selectors narrow the list and watch calls of a resource, keyed by its
+	// name in Resources, e.g. {"pods": {"field_selector": "status.phase!=Succeeded"}}.
+	Selectors map[string]internal.Selector `json:"selectors"`
+	// InCluster adds the service account of the pod the plugin runs in as a
+	// context named InClusterContext (default "in-cluster"), next to any
+	// kubeconfig contexts. Inside a pod without a kubeconfig it is used
+	// automatically.
+	InCluster        bool   `json:"in_cluster"`
+	InClusterContext string `json:"in_cluster_context"`
+	// MaxConcurrentContexts bounds how many kube contexts sync at once.
+	MaxConcurrentContexts int `json:"max_concurrent_contexts"`
+	// ContextTimeout limits how long a single context may take, e.g. "30m".
+	// Set to "0" to disable.
+	ContextTimeout string `json:"context_timeout"`
+	// PageSize is the number of objects requested per list call (default 500).
+	PageSize int64 `json:"page_size"`
+	// QPS and Burst limit the requests per context to its API server. Zero
+	// keeps client-go's defaults of 5 and 10.
+	QPS   float32 `json:"qps"`
+	Burst int     `json:"burst"`
+	// RequestTimeout bounds every API request except watches, e.g. "1m".
+	// Set to "0" to disable.
+	RequestTimeout string `json:"request_timeout"`
+	// MaxRetries is how often a throttled (429) or failed (5xx) read is
+	// retried (default 5). Set to -1 to disable retries.
+	MaxRetries int `json:"max_retries"`
+	// RetryBackoff is the delay before the first retry, doubling up to
+	// RetryMaxBackoff, with jitter (defaults "500ms" and "30s").
+	RetryBackoff    string `json:"retry_backoff"`
+	RetryMaxBackoff string `json:"retry_max_backoff"`
+	// ResyncPeriod is how often watch mode re-applies every cached object,
+	// e.g. "10m". Set to "0" to disable.
+	ResyncPeriod string `json:"resync_period"`
+	// MetricsAddress serves Prometheus metrics at /metrics in watch mode,
+	// e.g. ":9090".
+	MetricsAddress string `json:"metrics_address"`
+	// OTelEndpoint pushes traces and metrics over OTLP/HTTP in watch mode,
+	// e.g. "otel-collector:4318". Under cloudquery sync, the otel_endpoint
+	// of the source spec does the same.
+	OTelEndpoint         string `json:"otel_endpoint"`
+	OTelEndpointInsecure bool   `json:"otel_endpoint_insecure"`
+	// StaleRows controls what happens to rows of objects no longer in the
+	// cluster after a successful sync: "delete" (default) or "tombstone".
+	StaleRows string `json:"stale_rows"`
+	// Incremental fetches only the changes since the resourceVersion saved by
+	// the previous sync, falling back to a full list when it has expired.
+	// It requires DatabaseURL.
+	Incremental bool `json:"incremental"`
+	// IncrementalWindow is how long each incremental watch collects changes,
+	// e.g. "10s".
+	IncrementalWindow string `json:"incremental_window"`
+	// BatchSize is the number of rows written to database_url per round
+	// trip (default 1000).
+	BatchSize int `json:"batch_size"`
+	// History keeps every version of each row in a <table>_history table
+	// next to the database_url tables.
+	History bool `json:"history"`
+	// HistoryRetention prunes versions that ended longer ago than this,
+	// e.g. "2160h". Leave empty or "0" to keep them forever.
+	HistoryRetention string `json:"history_retention"`
+	// Strict makes Sync fail when any context or resource failed, instead
+	// of only logging it and recording it in k8s_sync_errors.
+	Strict bool `json:"strict"`
 }
 
 type SourceClient struct {
+	spec           Config
 	logger         zerolog.Logger
-	store          *internal.Store
-	contextFilter  map[string]struct{}
+	contextTimeout time.Duration
+	resyncPeriod   time.Duration
+	// incrementalWindow and historyRetention are the parsed config durations.
+	incrementalWindow time.Duration
+	historyRetention  time.Duration
+	// clientOptions are the request settings every context starts from.
+	clientOptions internal.ClientOptions
+	store         *internal.Store
+	kubeconfig    internal.Kubeconfig
+	// contexts are the resolved kube contexts; a single unnamed one is the
+	// current context.
+	contexts       []contextTarget
 	resourceFilter map[string]struct{}
 }
 
@@ -45,22 +149,75 @@ func NewSourceClient(ctx context.Context, logger zerolog.Logger, spec any) (plug
 	if err != nil {
 		return nil, err
 	}
+	return newSourceClient(ctx, logger, cfg)
+}
+
+func newSourceClient(ctx context.Context, logger zerolog.Logger, cfg Config) (*SourceClient, error) {
+	contextTimeout, _ := time.ParseDuration(cfg.ContextTimeout)
+	resyncPeriod, _ := time.ParseDuration(cfg.ResyncPeriod)
+	incrementalWindow, _ := time.ParseDuration(cfg.IncrementalWindow)
+	historyRetention, _ := time.ParseDuration(cfg.HistoryRetention)
+	requestTimeout, _ := time.ParseDuration(cfg.RequestTimeout)
+	retryBackoff, _ := time.ParseDuration(cfg.RetryBackoff)
+	retryMaxBackoff, _ := time.ParseDuration(cfg.RetryMaxBackoff)
+	client := &SourceClient{
+		spec:              cfg,
+		contextTimeout:    contextTimeout,
+		resyncPeriod:      resyncPeriod,
+		incrementalWindow: incrementalWindow,
+		historyRetention:  historyRetention,
+		clientOptions: internal.ClientOptions{
+			Impersonate:    cfg.Impersonate,
+			QPS:            cfg.QPS,
+			Burst:          cfg.Burst,
+			RequestTimeout: requestTimeout,
+			Retry: internal.RetryPolicy{
+				MaxRetries: max(cfg.MaxRetries, 0),
+				Backoff:    retryBackoff,
+				MaxBackoff: retryMaxBackoff,
+			},
+		},
+		kubeconfig:     internal.Kubeconfig{Paths: cfg.Kubeconfig},
+		logger:         logger,
+		resourceFilter: sliceToSet(cfg.Resources),
+	}
 
-	store, err := internal.NewStore(ctx, cfg.DatabaseURL)
+	contexts, err := resolveContexts(client.kubeconfig, cfg.Contexts, cfg.ExcludeContexts)
 	if err != nil {
 		return nil, err
 	}
-	if err := store.EnsureSchema(ctx); err != nil {
-		store.Close()
-		return nil, err
+	if len(contexts) == 0 {
+		logger.Info().Msg("syncing the current kube context")
+		contexts = []contextTarget{{}}
+	} else {
+		names := make([]string, len(contexts))
+		for i, target := range contexts {
+			names[i] = target.name
+		}
+		logger.Info().Strs("contexts", names).Msg("resolved kube contexts")
+	}
+	for i := range contexts {
+		contexts[i].settings = client.settingsFor(contexts[i].override)
 	}
+	client.contexts = contexts
 
-	return &SourceClient{
-		logger:         logger,
-		store:          store,
-		contextFilter:  sliceToSet(cfg.Contexts),
-		resourceFilter: sliceToSet(cfg.Resources),
-	}, nil
+	// Direct Postgres writes are optional; records are always emitted to the
+	// configured CloudQuery destinations.
+	if cfg.DatabaseURL != "" {
+		store, err := internal.NewStore(ctx, cfg.DatabaseURL)
+		if err != nil {
+			return nil, err
+		}
+		store.Tombstones = cfg.StaleRows == staleRowsTombstone
+		store.History = cfg.History
+		if err := store.EnsureSchema(ctx, allTables(), runTables()); err != nil {
+			store.Close()
+			return nil, err
+		}
+		client.store = store
+	}
+
+	return client, nil
 }
 
 func (c *SourceClient) Close(ctx context.Context) error {
@@ -71,269 +228,289 @@ func (c *SourceClient) Close(ctx context.Context) error {
 }
 
 func (c *SourceClient) Tables(ctx context.Context, options plugin.TableOptions) (schema.Tables, error) {
+	return append(c.syncTables(), runTables()...), nil
+}
+
+// syncTables returns the resource tables, marked incremental when
+// incremental sync is on: a sync then only emits what changed, so
+// destinations must keep the rows it did not fetch again and rely on the
+// deletes it sends instead.
+func (c *SourceClient) syncTables() schema.Tables {
+	tables := allTables()
+	if c.spec.Incremental {
+		for _, table := range tables {
+			// k8s_clusters is always fetched in full.
+			table.IsIncremental = table.Name != "k8s_clusters"
+		}
+	}
+	return tables
+}
+
+func allTables() schema.Tables {
 	return schema.Tables{
 		ClustersTable(),
 		NamespacesTable(),
+		NodesTable(),
 		PodsTable(),
 		DeploymentsTable(),
 		ServicesTable(),
 		CustomResourcesTable(),
-	}, nil
+	}
 }
 
-func (c *SourceClient) Sync(ctx context.Context, options plugin.SyncOptions, res chan<- message.SyncMessage) error {
-	// Migration handled elsewhere
-	if res != nil {
-	} // Silence unused parameter warning
+func (c *SourceClient) Sync(ctx context.Context, options plugin.SyncOptions, res chan<- message.SyncMessage) (err error) {
+	ctx, span := internal.StartSpan(ctx, "SourceClient.Sync")
+	defer func() { internal.EndSpan(span, err) }()
+
+	tables := c.syncTables()
+	// A table is synced when any context selects it; each context then
+	// only resolves its own selection.
+	selected := make(schema.Tables, 0, len(tables))
+	for _, table := range tables {
+		for _, target := range c.contexts {
+			if shouldSyncResource(target.settings.resourceFilter, tableResources[table.Name], table.Name, options) {
+				selected = append(selected, table)
+				break
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil
+	}
 
-	if len(c.contextFilter) == 0 {
-		// No contexts specified: use only the current context
-		client, err := internal.New(ctx)
+	// Every context runs its own scheduler, so migrate messages are sent once
+	// here and dropped from the per-context streams.
+	for _, table := range selected.FlattenTables() {
+		res <- &message.SyncMigrateTable{Table: table}
+	}
+	for _, table := range runTables() {
+		if runTableSelected(table.Name, options) {
+			res <- &message.SyncMigrateTable{Table: table}
+		}
+	}
+
+	run := newSyncRun()
+	for _, table := range selected {
+		run.track(table)
+	}
+
+	if c.spec.Incremental {
+		versions, closeVersions, err := c.versionBackend(ctx, options)
 		if err != nil {
 			return err
 		}
-		contextName := client.ID()
-		clusterUID := generateClusterUID(client)
+		defer closeVersions()
+		run.incremental = true
+		run.versions = versions
+	}
 
-		if c.shouldSyncResource("clusters", "k8s_clusters", options) {
-			if err := c.syncCluster(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync cluster")
-			}
-		}
-		if c.shouldSyncResource("namespaces", "k8s_namespaces", options) {
-			if err := c.syncNamespaces(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync namespaces")
-			}
-		}
-		if c.shouldSyncResource("pods", "k8s_pods", options) {
-			if err := c.syncPods(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync pods")
-			}
-		}
-		if c.shouldSyncResource("deployments", "k8s_deployments", options) {
-			if err := c.syncDeployments(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync deployments")
-			}
-		}
-		if c.shouldSyncResource("services", "k8s_services", options) {
-			if err := c.syncServices(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync services")
+	defer c.pruneHistory(ctx, selected)
+
+	if len(c.contexts) == 1 && c.contexts[0].name == "" {
+		// No contexts specified: use only the current context
+		if err := c.syncContext(ctx, run, c.contexts[0], selected, options, res); err != nil {
+			c.logger.Warn().Err(err).Msg("failed to sync context")
+			if !errors.Is(err, errIncomplete) {
+				run.fail(c.currentContext(), "", "", err)
 			}
 		}
-		if c.shouldSyncResource("crds", "k8s_custom_resources", options) {
-			if err := c.syncCRDs(ctx, client, contextName, clusterUID); err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync CRDs")
-			}
+		c.reportErrors(ctx, run, options, res)
+		c.reportRun(ctx, run, options, res)
+		if c.spec.Strict {
+			return run.err()
 		}
-		_ = client.Close(ctx)
 		return nil
-	} else {
-		// Contexts specified: use each one
-		for contextName := range c.contextFilter {
-			client, err := internal.NewForContext(ctx, contextName)
-			if err != nil {
-				c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to create client")
-				continue
-			}
-			clusterUID := generateClusterUID(client)
+	}
 
-			if c.shouldSyncResource("clusters", "k8s_clusters", options) {
-				if err := c.syncCluster(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync cluster")
-				}
-			}
-			if c.shouldSyncResource("namespaces", "k8s_namespaces", options) {
-				if err := c.syncNamespaces(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync namespaces")
-				}
-			}
-			if c.shouldSyncResource("pods", "k8s_pods", options) {
-				if err := c.syncPods(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync pods")
+	// Contexts specified: sync them concurrently, bounded by max_concurrent_contexts
+	var g errgroup.Group
+	g.SetLimit(c.spec.MaxConcurrentContexts)
+	for _, target := range c.contexts {
+		g.Go(func() error {
+			if err := c.syncContext(ctx, run, target, selected, options, res); err != nil {
+				c.logger.Warn().Err(err).Str("context", target.name).Msg("failed to sync context")
+				if !errors.Is(err, errIncomplete) {
+					run.fail(target.name, "", "", err)
 				}
 			}
-			if c.shouldSyncResource("deployments", "k8s_deployments", options) {
-				if err := c.syncDeployments(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync deployments")
-				}
-			}
-			if c.shouldSyncResource("services", "k8s_services", options) {
-				if err := c.syncServices(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync services")
-				}
-			}
-			if c.shouldSyncResource("crds", "k8s_custom_resources", options) {
-				if err := c.syncCRDs(ctx, client, contextName, clusterUID); err != nil {
-					c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to sync CRDs")
-				}
-			}
-			_ = client.Close(ctx)
-		}
-		return nil
+			return nil
+		})
 	}
-}
-
-func (c *SourceClient) shouldSyncResource(resourceName, tableName string, options plugin.SyncOptions) bool {
-	if !isSelected(c.resourceFilter, resourceName) {
-		return false
+	_ = g.Wait()
+	c.reportErrors(ctx, run, options, res)
+	c.reportRun(ctx, run, options, res)
+	if c.spec.Strict {
+		return run.err()
 	}
-	if len(options.Tables) == 0 && len(options.SkipTables) == 0 {
-		return true
-	}
-	return plugin.MatchesTable(tableName, options.Tables, options.SkipTables)
+	return nil
 }
 
-func (c *SourceClient) syncCluster(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	config := client.Config
-	server := ""
-	caFile := ""
-	insecureSkipVerify := false
-	clusterName := contextName
-	namespace := "default"
-	kubernetesVersion := ""
-	nodeCount := int64(0)
-
-	if contextCluster, contextNamespace, err := internal.GetContextDetails(contextName); err != nil {
-		c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to read context details")
-	} else {
-		if contextCluster != "" {
-			clusterName = contextCluster
-		}
-		if contextNamespace != "" {
-			namespace = contextNamespace
-		}
-	}
+// syncContext runs the scheduler for a single kube context under its own
+// timeout, so a hung API server only stalls its own worker.
+func (c *SourceClient) syncContext(ctx context.Context, run *syncRun, target contextTarget, selected schema.Tables, options plugin.SyncOptions, res chan<- message.SyncMessage) (err error) {
+	ctx, span := internal.StartSpan(ctx, "SourceClient.syncContext", internal.ContextKey.String(target.name))
+	defer func() { internal.EndSpan(span, err) }()
 
-	if config != nil && config.Host != "" {
-		server = config.Host
-		if config.CAFile != "" {
-			caFile = config.CAFile
+	tables := make(schema.Tables, 0, len(selected))
+	for _, table := range selected {
+		if isSelected(target.settings.resourceFilter, tableResources[table.Name]) {
+			tables = append(tables, table)
 		}
-		insecureSkipVerify = config.Insecure
-	}
-
-	if versionInfo, err := client.Clientset.Discovery().ServerVersion(); err != nil {
-		c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to read kubernetes version")
-	} else if versionInfo != nil {
-		kubernetesVersion = versionInfo.GitVersion
 	}
-
-	if nodes, err := client.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
-		c.logger.Warn().Err(err).Str("context", contextName).Msg("failed to list nodes")
-	} else {
-		nodeCount = int64(len(nodes.Items))
+	if len(tables) == 0 {
+		return nil
 	}
 
-	err := c.store.UpsertCluster(ctx, clusterUID, contextName, clusterName, server, caFile, insecureSkipVerify, namespace, kubernetesVersion, nodeCount)
-	if err != nil {
-		return err
+	if target.settings.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, target.settings.timeout)
+		defer cancel()
 	}
-	return nil
-}
 
-func (c *SourceClient) syncNamespaces(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	namespaces, err := client.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	client, err := c.newClient(ctx, target.name, target.settings.client)
 	if err != nil {
-		return err
+		return fmt.Errorf("create client: %w", err)
 	}
+	defer client.Close(ctx)
 
-	for _, ns := range namespaces.Items {
-		uid := string(ns.UID)
-		name := ns.Name
-		status := string(ns.Status.Phase)
-		createdAt := ns.CreationTimestamp.Time
-		err := c.store.UpsertNamespace(ctx, clusterUID, contextName, uid, name, status, createdAt)
+	// All writes for this context land in one snapshot, so readers see either
+	// the previous sync or this one, never a mix.
+	var snapshot *internal.Store
+	if c.store != nil {
+		snapshot, err = c.store.Begin(ctx)
 		if err != nil {
-			return err
+			return fmt.Errorf("begin snapshot: %w", err)
 		}
+		defer snapshot.Rollback(context.WithoutCancel(ctx))
 	}
-	return nil
-}
+	if err := c.prepareClient(ctx, client, run, snapshot, target.settings); err != nil {
+		return fmt.Errorf("context %s: %w", client.Context(), err)
+	}
+	span.SetAttributes(internal.ContextKey.String(client.Context()), internal.ClusterUIDKey.String(client.ClusterUID))
 
-func (c *SourceClient) syncPods(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	pods, err := client.Clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
+	logger := c.logger.With().Str("context", client.Context()).Logger()
+	started := logger.Info().Str("cluster_uid", client.ClusterUID)
+	if user := client.Config.Impersonate.UserName; user != "" {
+		started = started.Str("impersonate", user)
 	}
+	started.Msg("context sync started")
 
-	for _, pod := range pods.Items {
-		uid := string(pod.UID)
-		namespace := pod.Namespace
-		name := pod.Name
-		status := string(pod.Status.Phase)
-		createdAt := pod.CreationTimestamp.Time
-		err := c.store.UpsertPod(ctx, clusterUID, contextName, uid, namespace, name, status, createdAt)
-		if err != nil {
-			return err
+	msgs := make(chan message.SyncMessage)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			if _, ok := msg.(*message.SyncMigrateTable); ok {
+				continue
+			}
+			res <- msg
 		}
-	}
-	return nil
-}
+	}()
 
-func (c *SourceClient) syncDeployments(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	deployments, err := client.Clientset.AppsV1().Deployments("").List(ctx, metav1.ListOptions{})
+	s := scheduler.NewScheduler(scheduler.WithLogger(logger))
+	err = s.Sync(ctx, client, tables, msgs, scheduler.WithSyncDeterministicCQID(options.DeterministicCQID))
+	close(msgs)
+	<-done
 	if err != nil {
-		return err
+		return fmt.Errorf("context %s: %w", client.Context(), err)
 	}
 
-	for _, deployment := range deployments.Items {
-		uid := string(deployment.UID)
-		namespace := deployment.Namespace
-		name := deployment.Name
-		replicas := int32(deployment.Status.Replicas)
-		ready := int32(deployment.Status.ReadyReplicas)
-		createdAt := deployment.CreationTimestamp.Time
-		err := c.store.UpsertDeployment(ctx, clusterUID, contextName, uid, namespace, name, replicas, ready, createdAt)
-		if err != nil {
-			return err
+	c.flushWrites(ctx, run, client, tables)
+	if snapshot != nil {
+		if failed := run.incomplete(client.ID(), tables); len(failed) > 0 {
+			return fmt.Errorf("context %s: %w %s, kept the previous snapshot", client.Context(), errIncomplete, strings.Join(failed, ", "))
+		}
+	}
+	c.pruneStale(ctx, run, client, tables, res)
+	c.commitVersions(ctx, run, client, tables, res)
+	if snapshot != nil {
+		if err := snapshot.Commit(ctx); err != nil {
+			return fmt.Errorf("context %s: commit snapshot: %w", client.Context(), err)
 		}
 	}
+	run.finished(client)
+	logger.Info().Msg("context sync finished")
 	return nil
 }
 
-func (c *SourceClient) syncServices(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	services, err := client.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return err
+// newClient connects to contextName: through the pod's service account for
+// the in-cluster context, otherwise through the kubeconfig. The current
+// context ("") falls back to in-cluster inside a pod without a kubeconfig.
+func (c *SourceClient) newClient(ctx context.Context, contextName string, options internal.ClientOptions) (*internal.Client, error) {
+	options.Retry.OnRetry = func(retry internal.Retry) {
+		internal.RecordRetry(ctx, contextName, retry)
+		c.logger.Warn().Err(retry.Err).
+			Str("context", contextName).
+			Str("path", retry.Path).
+			Int("status", retry.StatusCode).
+			Int("attempt", retry.Attempt).
+			Dur("wait", retry.Wait).
+			Msg("retrying request")
+	}
+	if c.spec.InCluster && contextName == c.spec.InClusterContext {
+		return internal.NewInCluster(ctx, contextName, options)
+	}
+	if contextName == "" && internal.RunningInCluster() {
+		if contexts, err := internal.GetAvailableContexts(c.kubeconfig); err != nil || len(contexts) == 0 {
+			c.logger.Info().Msg("no kubeconfig found, using the in-cluster service account")
+			return internal.NewInCluster(ctx, c.spec.InClusterContext, options)
+		}
 	}
+	return internal.NewForContext(ctx, c.kubeconfig, contextName, options)
+}
 
-	for _, service := range services.Items {
-		uid := string(service.UID)
-		namespace := service.Namespace
-		name := service.Name
-		serviceType := string(service.Spec.Type)
-		clusterIP := service.Spec.ClusterIP
-		createdAt := service.CreationTimestamp.Time
-		err := c.store.UpsertService(ctx, clusterUID, contextName, uid, namespace, name, serviceType, clusterIP, createdAt)
-		if err != nil {
-			return err
-		}
+// currentContext names the context synced when none is selected, for
+// errors raised before its client exists.
+func (c *SourceClient) currentContext() string {
+	if name, err := internal.GetCurrentContext(c.kubeconfig); err == nil && name != "" {
+		return name
 	}
-	return nil
+	if internal.RunningInCluster() {
+		return c.spec.InClusterContext
+	}
+	return ""
 }
 
-func (c *SourceClient) syncCRDs(ctx context.Context, client *internal.Client, contextName, clusterUID string) error {
-	crds, err := client.ApiextensionsClientset.ApiextensionsV1().CustomResourceDefinitions().List(ctx, metav1.ListOptions{})
-	if err != nil {
+// prepareClient sets up client for run with the settings of its context,
+// writing to store when it is not nil.
+func (c *SourceClient) prepareClient(ctx context.Context, client *internal.Client, run *syncRun, store *internal.Store, settings contextSettings) error {
+	if err := c.identifyCluster(ctx, client, store, settings.clusterUID); err != nil {
 		return err
 	}
-
-	for _, crd := range crds.Items {
-		uid := string(crd.UID)
-		name := crd.Name
-		groupName := crd.Spec.Group
-		kind := crd.Spec.Names.Kind
-		plural := crd.Spec.Names.Plural
-		scope := string(crd.Spec.Scope)
-		createdAt := crd.CreationTimestamp.Time
-		err := c.store.UpsertCRD(ctx, clusterUID, contextName, uid, name, groupName, kind, plural, scope, createdAt)
-		if err != nil {
-			return err
+	client.Store = store
+	if store != nil {
+		client.Writer = store.NewBatchWriter(c.spec.BatchSize)
+	}
+	client.PageSize = settings.pageSize
+	client.Tags = settings.tags
+	client.Namespaces = settings.namespaces
+	client.Selectors = settings.selectors
+	client.SyncID = run.id
+	client.SyncedAt = run.startedAt
+	if run.incremental {
+		// Without a state backend, bookmarks are saved in the same snapshot
+		// as the rows they cover.
+		backend := run.versions
+		if backend == nil && store != nil {
+			backend = store
+		}
+		if backend != nil {
+			client.Versions = internal.NewResourceVersions(backend, client.ClusterUID, c.incrementalWindow)
 		}
 	}
 	return nil
 }
 
+func shouldSyncResource(resourceFilter map[string]struct{}, resourceName, tableName string, options plugin.SyncOptions) bool {
+	if !isSelected(resourceFilter, resourceName) {
+		return false
+	}
+	if len(options.Tables) == 0 && len(options.SkipTables) == 0 {
+		return true
+	}
+	return plugin.MatchesTable(tableName, options.Tables, options.SkipTables)
+}
+
 func loadConfig(spec any) (Config, error) {
 	cfg := Config{}
 	if spec == nil {
@@ -362,17 +539,118 @@ func loadConfig(spec any) (Config, error) {
 	if cfg.DatabaseURL == "" {
 		cfg.DatabaseURL = os.Getenv("DATABASE_URL")
 	}
-	if cfg.DatabaseURL == "" {
-		return cfg, errors.New("DATABASE_URL is not set")
-	}
 
 	if len(cfg.Contexts) == 0 {
-		cfg.Contexts = parseList(os.Getenv("K8S_CONTEXTS"))
+		cfg.Contexts = contextSpecs(parseList(os.Getenv("K8S_CONTEXTS")))
+	}
+	if len(cfg.ExcludeContexts) == 0 {
+		cfg.ExcludeContexts = parseList(os.Getenv("K8S_EXCLUDE_CONTEXTS"))
 	}
 	if len(cfg.Resources) == 0 {
 		cfg.Resources = parseList(os.Getenv("K8S_RESOURCES"))
 	}
 
+	if cfg.InClusterContext == "" {
+		cfg.InClusterContext = internal.DefaultInClusterContext
+	}
+	if cfg.InCluster {
+		// The in-cluster connection is synced like any listed context.
+		cfg.Contexts = append(cfg.Contexts, ContextSpec{Name: cfg.InClusterContext})
+	}
+	for _, spec := range cfg.Contexts {
+		if err := spec.validate(); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.Namespaces.Validate(); err != nil {
+		return cfg, err
+	}
+	if err := validateSelectors(cfg.Selectors); err != nil {
+		return cfg, err
+	}
+	if err := cfg.Impersonate.Validate(); err != nil {
+		return cfg, err
+	}
+
+	if cfg.MaxConcurrentContexts <= 0 {
+		cfg.MaxConcurrentContexts = defaultMaxConcurrentContexts
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = internal.DefaultPageSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = internal.DefaultBatchSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = internal.DefaultMaxRetries
+	}
+	if cfg.QPS < 0 || cfg.Burst < 0 {
+		return cfg, errors.New("qps and burst must not be negative")
+	}
+
+	if cfg.RequestTimeout == "" {
+		cfg.RequestTimeout = defaultRequestTimeout
+	}
+	if _, err := time.ParseDuration(cfg.RequestTimeout); err != nil {
+		return cfg, fmt.Errorf("invalid request_timeout: %w", err)
+	}
+	if cfg.RetryBackoff == "" {
+		cfg.RetryBackoff = internal.DefaultRetryBackoff.String()
+	}
+	if _, err := time.ParseDuration(cfg.RetryBackoff); err != nil {
+		return cfg, fmt.Errorf("invalid retry_backoff: %w", err)
+	}
+	if cfg.RetryMaxBackoff == "" {
+		cfg.RetryMaxBackoff = internal.DefaultRetryMaxBackoff.String()
+	}
+	if _, err := time.ParseDuration(cfg.RetryMaxBackoff); err != nil {
+		return cfg, fmt.Errorf("invalid retry_max_backoff: %w", err)
+	}
+
+	if cfg.ContextTimeout == "" {
+		cfg.ContextTimeout = defaultContextTimeout
+	}
+	if _, err := time.ParseDuration(cfg.ContextTimeout); err != nil {
+		return cfg, fmt.Errorf("invalid context_timeout: %w", err)
+	}
+
+	if cfg.ResyncPeriod == "" {
+		cfg.ResyncPeriod = defaultResyncPeriod
+	}
+	if _, err := time.ParseDuration(cfg.ResyncPeriod); err != nil {
+		return cfg, fmt.Errorf("invalid resync_period: %w", err)
+	}
+
+	if cfg.IncrementalWindow == "" {
+		cfg.IncrementalWindow = defaultIncrementalWindow
+	}
+	if _, err := time.ParseDuration(cfg.IncrementalWindow); err != nil {
+		return cfg, fmt.Errorf("invalid incremental_window: %w", err)
+	}
+
+	if cfg.HistoryRetention == "" {
+		cfg.HistoryRetention = "0"
+	}
+	if _, err := time.ParseDuration(cfg.HistoryRetention); err != nil {
+		return cfg, fmt.Errorf("invalid history_retention: %w", err)
+	}
+	if cfg.History && cfg.DatabaseURL == "" {
+		return cfg, errors.New("history requires database_url or DATABASE_URL")
+	}
+	if cfg.Incremental && cfg.DatabaseURL == "" {
+		// Without the stored rows, objects deleted while a bookmark was
+		// expired cannot be found and deleted from destinations.
+		return cfg, errors.New("incremental requires database_url or DATABASE_URL")
+	}
+
+	switch cfg.StaleRows {
+	case "":
+		cfg.StaleRows = staleRowsDelete
+	case staleRowsDelete, staleRowsTombstone:
+	default:
+		return cfg, fmt.Errorf("stale_rows must be %q or %q, got %q", staleRowsDelete, staleRowsTombstone, cfg.StaleRows)
+	}
+
 	return cfg, nil
 }
 
@@ -390,10 +668,35 @@ func unmarshalConfigBytes(value []byte, cfg *Config) error {
 	if err := json.Unmarshal(value, cfg); err == nil {
 		return nil
 	}
-	if err := yaml.Unmarshal(value, cfg); err == nil {
+	// Decode YAML generically and reuse the json tags, so both formats
+	// accept the same keys (e.g. database_url).
+	var generic map[string]any
+	if err := yaml.Unmarshal(value, &generic); err != nil {
+		return errors.New("spec must be valid JSON or YAML")
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, cfg)
+}
+
+// stringList accepts a single path list string, split like KUBECONFIG, or
+// a list of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*l = filepath.SplitList(single)
 		return nil
 	}
-	return errors.New("spec must be valid JSON or YAML")
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
 }
 
 func parseList(value string) []string {
@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/message"
+	"github.com/cloudquery/plugin-sdk/v4/plugin"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/types"
+	"github.com/google/uuid"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const syncErrorsTable = "k8s_sync_errors"
+
+// errIncomplete fails a context whose tables failed on their own; those
+// failures are already recorded per resource.
+var errIncomplete = errors.New("incomplete tables")
+
+// partialError is returned by a resolver that emitted its rows but could
+// not fill some of their columns, e.g. node_count without access to nodes.
+// Each error is recorded for the run without failing the table, so its
+// stale rows are still pruned and the snapshot is committed.
+type partialError struct {
+	errs []error
+}
+
+func (e *partialError) Error() string {
+	return errors.Join(e.errs...).Error()
+}
+
+func (e *partialError) Unwrap() []error {
+	return e.errs
+}
+
+// SyncErrorsTable lists what could not be synced: whole contexts, with an
+// empty resource, and single resources. It is filled at the end of every
+// sync rather than by a resolver, and is incremental so destinations keep
+// the errors of earlier syncs.
+func SyncErrorsTable() *schema.Table {
+	return &schema.Table{
+		Name:          syncErrorsTable,
+		IsIncremental: true,
+		Columns: []schema.Column{
+			{Name: "id", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
+			{Name: "sync_id", Type: types.ExtensionTypes.UUID, NotNull: true},
+			{Name: "context_name", Type: arrow.BinaryTypes.String},
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+			{Name: "resource", Type: arrow.BinaryTypes.String},
+			{Name: "status_code", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "reason", Type: arrow.BinaryTypes.String},
+			{Name: "message", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "occurred_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+		},
+	}
+}
+
+// fail records an error of contextName, or of one of its resources when
+// resource is set.
+func (r *syncRun) fail(contextName, clusterUID, resource string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addError(contextName, clusterUID, resource, err)
+}
+
+// addError appends err to the run's errors and counts it; r.mu must be held.
+func (r *syncRun) addError(contextName, clusterUID, resource string, err error) {
+	internal.RecordError(context.Background(), contextName, resource, err)
+	statusCode, reason := internal.ErrorDetails(err)
+	r.errs = append(r.errs, internal.SyncError{
+		ID:          uuid.New().String(),
+		SyncID:      r.id,
+		ContextName: contextName,
+		ClusterUID:  clusterUID,
+		Resource:    resource,
+		StatusCode:  statusCode,
+		Reason:      reason,
+		Message:     err.Error(),
+		OccurredAt:  time.Now(),
+	})
+}
+
+// errors returns the errors recorded so far.
+func (r *syncRun) errors() []internal.SyncError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]internal.SyncError(nil), r.errs...)
+}
+
+// err joins the recorded errors, or returns nil when there are none.
+func (r *syncRun) err() error {
+	var errs []error
+	for _, e := range r.errors() {
+		name := e.ContextName
+		if e.Resource != "" {
+			name += "/" + e.Resource
+		}
+		errs = append(errs, fmt.Errorf("%s: %s", name, e.Message))
+	}
+	return errors.Join(errs...)
+}
+
+// reportErrors writes the errors of run to k8s_sync_errors in the store and,
+// when the table is selected, to the destinations. Store writes are not part
+// of any snapshot, so they survive the rollback of a failed context.
+func (c *SourceClient) reportErrors(ctx context.Context, run *syncRun, options plugin.SyncOptions, res chan<- message.SyncMessage) {
+	errs := run.errors()
+	if len(errs) == 0 {
+		return
+	}
+	if c.store != nil {
+		if err := c.store.InsertSyncErrors(ctx, errs); err != nil {
+			c.logger.Warn().Err(err).Msg("failed to record sync errors")
+		}
+	}
+	if !runTableSelected(syncErrorsTable, options) {
+		return
+	}
+
+	rows := make([]map[string]any, len(errs))
+	for i, e := range errs {
+		rows[i] = map[string]any{
+			"id":           e.ID,
+			"sync_id":      e.SyncID,
+			"context_name": e.ContextName,
+			"cluster_uid":  e.ClusterUID,
+			"resource":     e.Resource,
+			"reason":       e.Reason,
+			"message":      e.Message,
+			"occurred_at":  e.OccurredAt,
+		}
+		if e.StatusCode != 0 {
+			rows[i]["status_code"] = int64(e.StatusCode)
+		}
+	}
+	c.emitRows(SyncErrorsTable(), rows, res)
+}
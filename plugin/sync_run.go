@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/message"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/types"
+	"github.com/google/uuid"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const (
+	staleRowsDelete    = "delete"
+	staleRowsTombstone = "tombstone"
+)
+
+// syncRun tracks which tables resolved cleanly for each context during a
+// single Sync, so stale rows are only pruned where the full list succeeded,
+// and what every table did for the sync ledger.
+type syncRun struct {
+	id        string
+	startedAt time.Time
+	// incremental is set when only changes are fetched; versions is the state
+	// backend keeping bookmarks, nil to keep them in the store.
+	incremental bool
+	versions    internal.VersionBackend
+
+	mu        sync.Mutex
+	succeeded map[runKey]struct{}
+	failed    map[runKey]struct{}
+	errs      []internal.SyncError
+	tables    map[runKey]*tableStats
+	// committed holds the contexts that finished their sync.
+	committed map[string]struct{}
+}
+
+type runKey struct {
+	context string
+	table   string
+}
+
+func newSyncRun() *syncRun {
+	return &syncRun{
+		id:        uuid.New().String(),
+		startedAt: time.Now(),
+		succeeded: map[runKey]struct{}{},
+		failed:    map[runKey]struct{}{},
+		tables:    map[runKey]*tableStats{},
+		committed: map[string]struct{}{},
+	}
+}
+
+// track wraps the table resolvers to record failed lists and failed writes,
+// how long each list took and how many rows it emitted.
+func (r *syncRun) track(table *schema.Table) {
+	resolver := table.Resolver
+	table.Resolver = func(ctx context.Context, meta schema.ClientMeta, parent *schema.Resource, res chan<- any) error {
+		client := meta.(*internal.Client)
+		resource := tableResources[table.Name]
+		ctx, span := internal.StartSpan(ctx, "SourceClient.resolve",
+			internal.ContextKey.String(client.Context()), internal.ResourceKey.String(resource), internal.TableKey.String(table.Name))
+		started := time.Now()
+		err := resolver(ctx, meta, parent, res)
+		internal.RecordList(ctx, client.Context(), resource, started, err)
+		internal.EndSpan(span, err)
+		r.resolved(client, table.Name, started)
+		r.record(client, table.Name, err)
+		if _, ok := err.(*partialError); ok {
+			return nil
+		}
+		return err
+	}
+	if post := table.PostResourceResolver; post != nil {
+		table.PostResourceResolver = func(ctx context.Context, meta schema.ClientMeta, resource *schema.Resource) error {
+			client := meta.(*internal.Client)
+			// The scheduler emits the row even when this fails.
+			r.emitted(client, table.Name)
+			internal.RecordObject(ctx, client.Context(), tableResources[table.Name])
+			err := post(ctx, meta, resource)
+			if err != nil {
+				r.record(client, table.Name, err)
+			}
+			return err
+		}
+	}
+}
+
+func (r *syncRun) record(client *internal.Client, tableName string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := runKey{context: client.ID(), table: tableName}
+	if partial, ok := err.(*partialError); ok {
+		for _, err := range partial.errs {
+			r.stats(client, tableName).errors++
+			r.addError(client.Context(), client.ClusterUID, tableResources[tableName], err)
+		}
+		r.succeeded[key] = struct{}{}
+		return
+	}
+	if err != nil {
+		r.failed[key] = struct{}{}
+		r.stats(client, tableName).errors++
+		r.addError(client.Context(), client.ClusterUID, tableResources[tableName], err)
+		return
+	}
+	r.succeeded[key] = struct{}{}
+}
+
+// complete reports whether every list and write for the table succeeded.
+func (r *syncRun) complete(contextName, tableName string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := runKey{context: contextName, table: tableName}
+	if _, ok := r.failed[key]; ok {
+		return false
+	}
+	_, ok := r.succeeded[key]
+	return ok
+}
+
+// flushWrites writes the rows still batched for every table of client and
+// records failed batches, so their tables are not treated as complete, and
+// how many rows were inserted and updated.
+func (c *SourceClient) flushWrites(ctx context.Context, run *syncRun, client *internal.Client, tables schema.Tables) {
+	if client.Writer == nil {
+		return
+	}
+	for _, table := range tables.FlattenTables() {
+		if err := client.Writer.Flush(ctx, table.Name); err != nil {
+			run.record(client, table.Name, err)
+			c.logger.Warn().Err(err).Str("context", client.Context()).Str("table", table.Name).Msg("failed to write rows")
+		}
+		run.written(client, table.Name, client.Writer.Counts(table.Name))
+	}
+}
+
+// incomplete returns the tables of contextName whose list or writes failed.
+func (r *syncRun) incomplete(contextName string, tables schema.Tables) []string {
+	var failed []string
+	for _, table := range tables {
+		if !r.complete(contextName, table.Name) {
+			failed = append(failed, table.Name)
+		}
+	}
+	return failed
+}
+
+// pruneHistory drops history versions older than history_retention.
+func (c *SourceClient) pruneHistory(ctx context.Context, tables schema.Tables) {
+	if c.store == nil || !c.spec.History || c.historyRetention <= 0 {
+		return
+	}
+	before := time.Now().Add(-c.historyRetention)
+	for _, table := range tables {
+		count, err := c.store.PruneHistory(ctx, table.Name, before)
+		if err != nil {
+			c.logger.Warn().Err(err).Str("table", table.Name).Msg("failed to prune history")
+			continue
+		}
+		if count > 0 {
+			c.logger.Info().Int64("rows", count).Str("table", table.Name).Msg("pruned history")
+		}
+	}
+}
+
+// syncColumns stamp every row with the sync that last saw it. Rows that a
+// later successful sync did not see are deleted or tombstoned via deleted_at.
+func syncColumns() []schema.Column {
+	return []schema.Column{
+		{Name: "sync_id", Type: types.ExtensionTypes.UUID, Resolver: resolveSyncID},
+		{Name: "synced_at", Type: arrow.FixedWidthTypes.Timestamp_ns, Resolver: resolveSyncedAt},
+		{Name: "deleted_at", Type: arrow.FixedWidthTypes.Timestamp_ns},
+	}
+}
+
+func resolveSyncID(ctx context.Context, meta schema.ClientMeta, resource *schema.Resource, c schema.Column) error {
+	return resource.Set(c.Name, meta.(*internal.Client).SyncID)
+}
+
+func resolveSyncedAt(ctx context.Context, meta schema.ClientMeta, resource *schema.Resource, c schema.Column) error {
+	return resource.Set(c.Name, meta.(*internal.Client).SyncedAt)
+}
+
+// pruneStale removes or tombstones rows that the run did not see, for every
+// table of client whose list and writes all succeeded, except in the
+// namespaces of which only changes were fetched. Destinations of
+// incremental tables are sent a delete for each of them, as they keep rows a
+// sync did not emit.
+func (c *SourceClient) pruneStale(ctx context.Context, run *syncRun, client *internal.Client, tables schema.Tables, res chan<- message.SyncMessage) {
+	if client.Store == nil {
+		return
+	}
+	for _, table := range tables {
+		if table.Columns.Get("sync_id") == nil {
+			continue
+		}
+		logger := c.logger.With().Str("context", client.Context()).Str("table", table.Name).Logger()
+		if !run.complete(client.ID(), table.Name) {
+			logger.Warn().Msg("skipping stale row cleanup after incomplete sync")
+			continue
+		}
+
+		// Where only changes were fetched, unchanged rows were not restamped.
+		var keep []string
+		if client.Versions != nil {
+			var all bool
+			if keep, all = client.Versions.Incremental(tableResources[table.Name]); all {
+				continue
+			}
+		}
+
+		var stale []string
+		if table.IsIncremental {
+			var err error
+			stale, err = client.Store.StaleObjects(ctx, table.Name, client.ClusterUID, run.id, keep)
+			if err != nil {
+				logger.Warn().Err(err).Msg("failed to clean up stale rows")
+				continue
+			}
+		}
+		count, err := client.Store.RemoveStale(ctx, table.Name, client.ClusterUID, run.id, keep, run.startedAt)
+		if err != nil {
+			logger.Warn().Err(err).Msg("failed to clean up stale rows")
+			continue
+		}
+		c.emitDeletes(table, client.ClusterUID, stale, res)
+		run.removed(client, table.Name, count)
+		if count > 0 {
+			logger.Info().Int64("rows", count).Str("mode", c.spec.StaleRows).Msg("cleaned up stale rows")
+		}
+	}
+}
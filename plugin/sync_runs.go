@@ -0,0 +1,312 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/cloudquery/plugin-sdk/v4/message"
+	"github.com/cloudquery/plugin-sdk/v4/plugin"
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/cloudquery/plugin-sdk/v4/types"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const (
+	syncRunsTable         = "k8s_sync_runs"
+	syncRunResourcesTable = "k8s_sync_run_resources"
+)
+
+// runTables are written once at the end of every sync instead of by
+// resolvers.
+func runTables() schema.Tables {
+	return schema.Tables{
+		SyncErrorsTable(),
+		SyncRunsTable(),
+		SyncRunResourcesTable(),
+	}
+}
+
+// SyncRunsTable has one row per sync. Like the other run tables it is
+// incremental, so destinations keep the rows of earlier syncs.
+func SyncRunsTable() *schema.Table {
+	return &schema.Table{
+		Name:          syncRunsTable,
+		IsIncremental: true,
+		Columns: []schema.Column{
+			{Name: "sync_id", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
+			{Name: "plugin_version", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "status", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "incremental", Type: arrow.FixedWidthTypes.Boolean, NotNull: true},
+			{Name: "started_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "finished_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "context_count", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "row_count", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "error_count", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+		},
+	}
+}
+
+// SyncRunResourcesTable has one row per sync, context and resource.
+func SyncRunResourcesTable() *schema.Table {
+	return &schema.Table{
+		Name:          syncRunResourcesTable,
+		IsIncremental: true,
+		Columns: []schema.Column{
+			{Name: "sync_id", Type: types.ExtensionTypes.UUID, PrimaryKey: true},
+			{Name: "context_name", Type: arrow.BinaryTypes.String, PrimaryKey: true},
+			{Name: "resource", Type: arrow.BinaryTypes.String, PrimaryKey: true},
+			{Name: "cluster_uid", Type: arrow.BinaryTypes.String},
+			{Name: "table_name", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "status", Type: arrow.BinaryTypes.String, NotNull: true},
+			{Name: "started_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "finished_at", Type: arrow.FixedWidthTypes.Timestamp_ns, NotNull: true},
+			{Name: "row_count", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "inserted", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "updated", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "deleted", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "error_count", Type: arrow.PrimitiveTypes.Int64, NotNull: true},
+			{Name: "label_selector", Type: arrow.BinaryTypes.String},
+			{Name: "field_selector", Type: arrow.BinaryTypes.String},
+			{Name: "namespace_filter", Type: types.ExtensionTypes.JSON},
+		},
+	}
+}
+
+// namespaceFiltered are the resources the namespace filter of a context
+// applies to.
+var namespaceFiltered = map[string]struct{}{
+	"namespaces":  {},
+	"pods":        {},
+	"deployments": {},
+	"services":    {},
+}
+
+// tableStats is what a context did for one table during a run.
+type tableStats struct {
+	contextName string
+	clusterUID  string
+	startedAt   time.Time
+	finishedAt  time.Time
+	rows        int64
+	writes      internal.WriteCounts
+	deleted     int64
+	errors      int64
+	// selector and namespaces are the filters the table was listed with.
+	selector   internal.Selector
+	namespaces *internal.NamespaceFilter
+}
+
+// stats returns the stats of client's table; r.mu must be held.
+func (r *syncRun) stats(client *internal.Client, tableName string) *tableStats {
+	key := runKey{context: client.ID(), table: tableName}
+	stats, ok := r.tables[key]
+	if !ok {
+		resource := tableResources[tableName]
+		stats = &tableStats{contextName: client.Context(), startedAt: time.Now(), selector: client.Selectors[resource]}
+		if _, ok := namespaceFiltered[resource]; ok && !client.Namespaces.IsZero() {
+			namespaces := client.Namespaces
+			stats.namespaces = &namespaces
+		}
+		r.tables[key] = stats
+	}
+	stats.clusterUID = client.ClusterUID
+	return stats
+}
+
+// resolved records when the resolver of table started and finished.
+func (r *syncRun) resolved(client *internal.Client, tableName string, started time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(client, tableName)
+	stats.startedAt = started
+	stats.finishedAt = time.Now()
+}
+
+// emitted counts a row of table.
+func (r *syncRun) emitted(client *internal.Client, tableName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats(client, tableName).rows++
+}
+
+// written adds the rows of table the store inserted and updated.
+func (r *syncRun) written(client *internal.Client, tableName string, counts internal.WriteCounts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats(client, tableName)
+	stats.writes.Inserted += counts.Inserted
+	stats.writes.Updated += counts.Updated
+}
+
+// removed adds rows of table deleted or tombstoned from the store.
+func (r *syncRun) removed(client *internal.Client, tableName string, count int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats(client, tableName).deleted += count
+}
+
+// finished marks the context of client as synced, with its snapshot
+// committed when there is one.
+func (r *syncRun) finished(client *internal.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.committed[client.ID()] = struct{}{}
+}
+
+// ledger returns the summary of the run and its per-resource rows.
+func (r *syncRun) ledger(contexts int) (internal.SyncRun, []internal.SyncRunResource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := internal.SyncRun{
+		SyncID:        r.id,
+		PluginVersion: pluginVersion,
+		Incremental:   r.incremental,
+		StartedAt:     r.startedAt,
+		FinishedAt:    time.Now(),
+		ContextCount:  int64(contexts),
+		ErrorCount:    int64(len(r.errs)),
+	}
+	resources := make([]internal.SyncRunResource, 0, len(r.tables))
+	succeeded := 0
+	for key, stats := range r.tables {
+		status := internal.SyncFailed
+		_, committed := r.committed[key.context]
+		_, failed := r.failed[key]
+		if _, ok := r.succeeded[key]; ok && committed && !failed {
+			status = internal.SyncSucceeded
+			succeeded++
+		}
+		finishedAt := stats.finishedAt
+		if finishedAt.IsZero() {
+			finishedAt = summary.FinishedAt
+		}
+		resources = append(resources, internal.SyncRunResource{
+			SyncID:        r.id,
+			ContextName:   stats.contextName,
+			Resource:      tableResources[key.table],
+			ClusterUID:    stats.clusterUID,
+			Table:         key.table,
+			Status:        status,
+			StartedAt:     stats.startedAt,
+			FinishedAt:    finishedAt,
+			RowCount:      stats.rows,
+			Inserted:      stats.writes.Inserted,
+			Updated:       stats.writes.Updated,
+			Deleted:       stats.deleted,
+			ErrorCount:    stats.errors,
+			LabelSelector: stats.selector.LabelSelector,
+			FieldSelector: stats.selector.FieldSelector,
+			Namespaces:    stats.namespaces,
+		})
+		summary.RowCount += stats.rows
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].ContextName != resources[j].ContextName {
+			return resources[i].ContextName < resources[j].ContextName
+		}
+		return resources[i].Resource < resources[j].Resource
+	})
+
+	switch {
+	case summary.ErrorCount == 0:
+		summary.Status = internal.SyncSucceeded
+	case succeeded > 0:
+		summary.Status = internal.SyncPartial
+	default:
+		summary.Status = internal.SyncFailed
+	}
+	return summary, resources
+}
+
+// reportRun writes the run to the sync ledger in the store and, when its
+// tables are selected, to the destinations.
+func (c *SourceClient) reportRun(ctx context.Context, run *syncRun, options plugin.SyncOptions, res chan<- message.SyncMessage) {
+	summary, resources := run.ledger(len(c.contexts))
+	if c.store != nil {
+		if err := c.store.InsertSyncRun(ctx, summary, resources); err != nil {
+			c.logger.Warn().Err(err).Msg("failed to record sync run")
+		}
+	}
+	c.logger.Info().
+		Str("sync_id", summary.SyncID).
+		Str("status", summary.Status).
+		Int64("rows", summary.RowCount).
+		Int64("errors", summary.ErrorCount).
+		Dur("duration", summary.FinishedAt.Sub(summary.StartedAt)).
+		Msg("sync finished")
+
+	if runTableSelected(syncRunsTable, options) {
+		c.emitRows(SyncRunsTable(), []map[string]any{{
+			"sync_id":        summary.SyncID,
+			"plugin_version": summary.PluginVersion,
+			"status":         summary.Status,
+			"incremental":    summary.Incremental,
+			"started_at":     summary.StartedAt,
+			"finished_at":    summary.FinishedAt,
+			"context_count":  summary.ContextCount,
+			"row_count":      summary.RowCount,
+			"error_count":    summary.ErrorCount,
+		}}, res)
+	}
+	if runTableSelected(syncRunResourcesTable, options) && len(resources) > 0 {
+		rows := make([]map[string]any, len(resources))
+		for i, r := range resources {
+			rows[i] = map[string]any{
+				"sync_id":          r.SyncID,
+				"context_name":     r.ContextName,
+				"resource":         r.Resource,
+				"cluster_uid":      r.ClusterUID,
+				"table_name":       r.Table,
+				"status":           r.Status,
+				"started_at":       r.StartedAt,
+				"finished_at":      r.FinishedAt,
+				"row_count":        r.RowCount,
+				"inserted":         r.Inserted,
+				"updated":          r.Updated,
+				"deleted":          r.Deleted,
+				"error_count":      r.ErrorCount,
+				"label_selector":   nullIfEmpty(r.LabelSelector),
+				"field_selector":   nullIfEmpty(r.FieldSelector),
+				"namespace_filter": nil,
+			}
+			if r.Namespaces != nil {
+				rows[i]["namespace_filter"] = r.Namespaces
+			}
+		}
+		c.emitRows(SyncRunResourcesTable(), rows, res)
+	}
+}
+
+// nullIfEmpty writes an empty string as NULL, as the store does.
+func nullIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// emitRows sends rows of a run table to the destinations. Run tables have
+// no resolvers, so the records are built here instead of by the scheduler.
+func (c *SourceClient) emitRows(table *schema.Table, rows []map[string]any, res chan<- message.SyncMessage) {
+	sc := table.ToArrowSchema()
+	for _, row := range rows {
+		resource := schema.NewResourceData(table, nil, nil)
+		for column, value := range row {
+			if err := resource.Set(column, value); err != nil {
+				c.logger.Warn().Err(err).Str("table", table.Name).Str("column", column).Msg("failed to build row")
+			}
+		}
+		res <- &message.SyncInsert{Record: resource.GetValues().ToArrowRecord(sc)}
+	}
+}
+
+func runTableSelected(tableName string, options plugin.SyncOptions) bool {
+	if len(options.Tables) == 0 && len(options.SkipTables) == 0 {
+		return true
+	}
+	return plugin.MatchesTable(tableName, options.Tables, options.SkipTables)
+}
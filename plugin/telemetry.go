@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// telemetryShutdownTimeout bounds flushing the exporters on exit.
+const telemetryShutdownTimeout = 10 * time.Second
+
+// startTelemetry installs the exporters of watch mode: a Prometheus endpoint
+// at metrics_address and OTLP at otel_endpoint. Under cloudquery sync the
+// SDK installs the OTLP exporters instead. The returned func flushes and
+// stops them.
+func startTelemetry(ctx context.Context, logger zerolog.Logger, cfg Config) (func(), error) {
+	if cfg.MetricsAddress == "" && cfg.OTelEndpoint == "" {
+		return func() {}, nil
+	}
+
+	res := resource.NewSchemaless(semconv.ServiceName(pluginName), semconv.ServiceVersion(pluginVersion))
+	meterOptions := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	var (
+		tracerProvider *sdktrace.TracerProvider
+		server         *http.Server
+	)
+
+	if cfg.OTelEndpoint != "" {
+		traceOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.OTelEndpoint)}
+		metricOptions := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(cfg.OTelEndpoint)}
+		if cfg.OTelEndpointInsecure {
+			traceOptions = append(traceOptions, otlptracehttp.WithInsecure())
+			metricOptions = append(metricOptions, otlpmetrichttp.WithInsecure())
+		}
+		traceExporter, err := otlptracehttp.New(ctx, traceOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+		}
+		metricExporter, err := otlpmetrichttp.New(ctx, metricOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP metric exporter: %w", err)
+		}
+		meterOptions = append(meterOptions, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+		tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+	}
+
+	if cfg.MetricsAddress != "" {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+		if err != nil {
+			return nil, fmt.Errorf("create Prometheus exporter: %w", err)
+		}
+		meterOptions = append(meterOptions, sdkmetric.WithReader(exporter))
+
+		listener, err := net.Listen("tcp", cfg.MetricsAddress)
+		if err != nil {
+			return nil, fmt.Errorf("listen on metrics_address: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error().Err(err).Msg("metrics server stopped")
+			}
+		}()
+		logger.Info().Str("address", listener.Addr().String()).Msg("serving metrics at /metrics")
+	}
+
+	meterProvider := sdkmetric.NewMeterProvider(meterOptions...)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn().Err(err).Msg("otel error")
+	}))
+	otel.SetMeterProvider(meterProvider)
+	if tracerProvider != nil {
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), telemetryShutdownTimeout)
+		defer cancel()
+		if server != nil {
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Warn().Err(err).Msg("failed to stop metrics server")
+			}
+		}
+		if tracerProvider != nil {
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				logger.Warn().Err(err).Msg("failed to flush traces")
+			}
+		}
+		if err := meterProvider.Shutdown(ctx); err != nil {
+			logger.Warn().Err(err).Msg("failed to flush metrics")
+		}
+	}, nil
+}
@@ -0,0 +1,508 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+const (
+	// stubAnnotation marks placeholder objects rebuilt from stored rows when
+	// a watch resumes; they are never written back to the store.
+	stubAnnotation = "cq-k8s-custom/stub"
+
+	// watchStateFlushInterval is how often applied resourceVersions are saved.
+	watchStateFlushInterval = 10 * time.Second
+
+	// watchStartBackoff is the delay before retrying a watch that failed to
+	// start; it doubles up to watchStartMaxBackoff.
+	watchStartBackoff    = time.Second
+	watchStartMaxBackoff = 5 * time.Minute
+)
+
+// Watch runs watch mode until ctx is cancelled: every selected context gets
+// shared informers whose add, update and delete events are applied to the
+// store as they arrive.
+func Watch(ctx context.Context, logger zerolog.Logger, spec any) error {
+	cfg, err := loadConfig(spec)
+	if err != nil {
+		return err
+	}
+	if cfg.DatabaseURL == "" {
+		return errors.New("watch mode requires database_url or DATABASE_URL")
+	}
+	stopTelemetry, err := startTelemetry(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer stopTelemetry()
+	client, err := newSourceClient(ctx, logger, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close(ctx)
+	return client.Watch(ctx)
+}
+
+// Watch keeps the store in sync with every selected context until ctx is
+// cancelled. It fails once no context is being watched anymore.
+func (c *SourceClient) Watch(ctx context.Context) error {
+	if c.store == nil {
+		return errors.New("watch mode requires database_url or DATABASE_URL")
+	}
+
+	var (
+		g      errgroup.Group
+		mu     sync.Mutex
+		failed []error
+	)
+	for _, target := range c.contexts {
+		g.Go(func() error {
+			if err := c.watchContext(ctx, target); err != nil {
+				c.logger.Error().Err(err).Str("context", target.name).Msg("watch stopped")
+				mu.Lock()
+				failed = append(failed, fmt.Errorf("context %s: %w", target.name, err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	// Contexts only return before ctx is done when they failed.
+	if ctx.Err() == nil && len(failed) > 0 {
+		return fmt.Errorf("no context is being watched: %w", errors.Join(failed...))
+	}
+	return nil
+}
+
+func (c *SourceClient) watchContext(ctx context.Context, target contextTarget) error {
+	client, err := c.newClient(ctx, target.name, target.settings.client)
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	defer client.Close(ctx)
+	if err := c.prepareClient(ctx, client, newSyncRun(), c.store, target.settings); err != nil {
+		return err
+	}
+	resourceFilter := target.settings.resourceFilter
+
+	logger := c.logger.With().Str("context", client.Context()).Logger()
+	ctx = logger.WithContext(ctx)
+	logger.Info().Str("cluster_uid", client.ClusterUID).Dur("resync_period", c.resyncPeriod).Msg("watch started")
+
+	scope, err := client.NamespaceScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	if isSelected(resourceFilter, "clusters") {
+		if err := c.storeCluster(ctx, client); err != nil {
+			logger.Warn().Err(err).Msg("failed to store cluster")
+		}
+	}
+
+	started := make(chan *resourceWatch)
+	var starting sync.WaitGroup
+	for _, resource := range watchedResources {
+		if !isSelected(resourceFilter, resource.resource) {
+			continue
+		}
+		for _, namespace := range watchNamespaces(resource, scope) {
+			starting.Go(func() {
+				c.startWatch(ctx, client, resource, scope, namespace, logger, started)
+			})
+		}
+	}
+	var watches []*resourceWatch
+
+	flush := time.NewTicker(watchStateFlushInterval)
+	defer flush.Stop()
+	var resync <-chan time.Time
+	if c.resyncPeriod > 0 && isSelected(resourceFilter, "clusters") {
+		ticker := time.NewTicker(c.resyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Collect the watches that finished starting meanwhile, then save
+			// how far every watch got so the next start can resume from there.
+			go func() {
+				starting.Wait()
+				close(started)
+			}()
+			for w := range started {
+				watches = append(watches, w)
+			}
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+			defer cancel()
+			for _, w := range watches {
+				w.flush(flushCtx)
+			}
+			logger.Info().Msg("watch stopped")
+			return nil
+		case w := <-started:
+			watches = append(watches, w)
+		case <-flush.C:
+			for _, w := range watches {
+				w.flush(ctx)
+			}
+		case <-resync:
+			if err := c.storeCluster(ctx, client); err != nil {
+				logger.Warn().Err(err).Msg("failed to store cluster")
+			}
+		}
+	}
+}
+
+// startWatch starts the informer of resource and hands it to started, which
+// watchContext drains until every startWatch returned, so the send never
+// blocks for good. A watch that fails to start, e.g. because the store is
+// briefly unavailable, is retried with backoff until ctx is done.
+func (c *SourceClient) startWatch(ctx context.Context, client *internal.Client, resource watchedResource, scope *internal.NamespaceScope, namespace string, logger zerolog.Logger, started chan<- *resourceWatch) {
+	backoff := watchStartBackoff
+	for {
+		w, err := c.newResourceWatch(ctx, client, resource, scope, namespace, logger)
+		if err == nil {
+			go w.informer.RunWithContext(ctx)
+			go w.waitForSync(ctx)
+			started <- w
+			return
+		}
+		logger.Warn().Err(err).Str("resource", resource.resource).Str("namespace", namespace).Dur("retry_in", backoff).Msg("failed to start watch")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, watchStartMaxBackoff)
+	}
+}
+
+// watchNamespaces returns the namespaces resource is watched in one at a
+// time, like fetchNamespaced lists them, or "" for a single cluster-wide
+// watch. Namespace-scoped credentials are then enough.
+func watchNamespaces(resource watchedResource, scope *internal.NamespaceScope) []string {
+	if !resource.namespaced || scope == nil || scope.Namespaces == nil {
+		return []string{metav1.NamespaceAll}
+	}
+	return scope.Namespaces
+}
+
+// storeCluster refreshes the k8s_clusters row for client.
+func (c *SourceClient) storeCluster(ctx context.Context, client *internal.Client) error {
+	rows := make(chan interface{}, 1)
+	// A partial error was logged by the resolver; the row is still written.
+	if err := fetchClusters(ctx, client, nil, rows); err != nil {
+		if _, ok := err.(*partialError); !ok {
+			return err
+		}
+	}
+	close(rows)
+
+	table := ClustersTable()
+	for row := range rows {
+		resource, err := resolveRow(ctx, client, table, row.(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+		if err := c.store.UpsertResource(ctx, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceWatch applies the informer events of one resource in one context,
+// and in one namespace when it is watched per namespace, to the store.
+type resourceWatch struct {
+	ctx      context.Context
+	source   *SourceClient
+	client   *internal.Client
+	resource watchedResource
+	scope    *internal.NamespaceScope
+	// key is the bookmark key, which includes the resource's selector.
+	key          string
+	table        *schema.Table
+	logger       zerolog.Logger
+	informer     cache.SharedIndexInformer
+	registration cache.ResourceEventHandlerRegistration
+
+	mu        sync.Mutex
+	appliedRV string
+	savedRV   string
+}
+
+func (c *SourceClient) newResourceWatch(ctx context.Context, client *internal.Client, resource watchedResource, scope *internal.NamespaceScope, namespace string, logger zerolog.Logger) (*resourceWatch, error) {
+	selector := client.ListOptions(resource.resource)
+	key := resource.resource
+	logContext := logger.With().Str("resource", resource.resource)
+	if namespace != "" {
+		key = internal.NamespacedKey(key, namespace)
+		logContext = logContext.Str("namespace", namespace)
+	}
+	w := &resourceWatch{
+		ctx:      ctx,
+		source:   c,
+		client:   client,
+		resource: resource,
+		scope:    scope,
+		key:      internal.SelectorKey(key, selector),
+		table:    resource.table(),
+		logger:   logContext.Logger(),
+	}
+
+	lw := &resumingListWatch{
+		list:     resource.list(client, namespace),
+		watch:    resource.watch(client, namespace),
+		selector: selector,
+	}
+
+	// Resume from the last applied resourceVersion: the first list is served
+	// from stored rows and the watch picks up only the changes since then.
+	savedRV, err := c.store.ResourceVersion(ctx, client.ClusterUID, w.key)
+	if err != nil {
+		return nil, err
+	}
+	if savedRV != "" {
+		keys, err := c.store.ObjectKeys(ctx, w.table, client.ClusterUID)
+		if err != nil {
+			return nil, err
+		}
+		if namespace != "" {
+			keys = slices.DeleteFunc(keys, func(key internal.ObjectKey) bool {
+				return key.Namespace != namespace
+			})
+		}
+		lw.resumeList, err = w.stubList(keys, savedRV)
+		if err != nil {
+			return nil, err
+		}
+		w.savedRV = savedRV
+		w.logger.Info().Str("resource_version", savedRV).Int("objects", len(keys)).Msg("resuming watch")
+	}
+
+	w.informer = cache.NewSharedIndexInformer(lw, resource.object, c.resyncPeriod, cache.Indexers{})
+	w.registration, err = w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.apply(obj, true)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Periodic resyncs redeliver unchanged objects; they are written
+			// again but do not move the saved resourceVersion.
+			w.apply(newObj, resourceVersion(oldObj) != resourceVersion(newObj))
+		},
+		DeleteFunc: w.remove,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// waitForSync records the list resourceVersion once the initial list has
+// been applied, so a restart can resume from it.
+func (w *resourceWatch) waitForSync(ctx context.Context) {
+	if !cache.WaitForCacheSync(ctx.Done(), w.registration.HasSynced) {
+		return
+	}
+	w.mu.Lock()
+	if w.appliedRV == "" {
+		w.appliedRV = w.informer.LastSyncResourceVersion()
+	}
+	w.mu.Unlock()
+	w.logger.Info().Msg("watch synced")
+	w.flush(ctx)
+}
+
+func (w *resourceWatch) apply(obj interface{}, track bool) {
+	object, ok := obj.(runtime.Object)
+	if !ok || isStub(object) || !w.inScope(object) {
+		return
+	}
+	resource, err := resolveRow(w.ctx, w.client, w.table, w.resource.row(w.client, object))
+	if err == nil {
+		err = resource.Set("synced_at", time.Now())
+	}
+	if err == nil {
+		err = w.source.store.UpsertResource(w.ctx, resource)
+	}
+	if err != nil {
+		internal.RecordError(w.ctx, w.client.Context(), w.resource.resource, err)
+		w.logger.Warn().Err(err).Msg("failed to apply watch event")
+		return
+	}
+	internal.RecordObject(w.ctx, w.client.Context(), w.resource.resource)
+	if track {
+		w.setApplied(resourceVersion(obj))
+	}
+}
+
+func (w *resourceWatch) remove(obj interface{}) {
+	track := true
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		// The final state was missed; its resourceVersion is not current.
+		obj = tombstone.Obj
+		track = false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		w.logger.Warn().Err(err).Msg("failed to read deleted object")
+		return
+	}
+	uid := string(accessor.GetUID())
+	if err := w.source.store.RemoveObject(w.ctx, w.table.Name, w.client.ClusterUID, uid, time.Now()); err != nil {
+		internal.RecordError(w.ctx, w.client.Context(), w.resource.resource, err)
+		w.logger.Warn().Err(err).Msg("failed to apply watch delete")
+		return
+	}
+	if track && !isStub(obj.(runtime.Object)) {
+		w.setApplied(accessor.GetResourceVersion())
+	}
+}
+
+// inScope reports whether obj is in the namespaces the context is limited
+// to. Cluster-scoped objects other than namespaces always are.
+func (w *resourceWatch) inScope(obj runtime.Object) bool {
+	if namespace, ok := obj.(*corev1.Namespace); ok {
+		return w.scope.AllowsNamespace(namespace)
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil || accessor.GetNamespace() == "" {
+		return true
+	}
+	return w.scope.Allows(accessor.GetNamespace())
+}
+
+func (w *resourceWatch) setApplied(resourceVersion string) {
+	if resourceVersion == "" {
+		return
+	}
+	w.mu.Lock()
+	w.appliedRV = resourceVersion
+	w.mu.Unlock()
+}
+
+// flush saves the last applied resourceVersion if it moved.
+func (w *resourceWatch) flush(ctx context.Context) {
+	w.mu.Lock()
+	applied, saved := w.appliedRV, w.savedRV
+	w.mu.Unlock()
+	if applied == "" || applied == saved {
+		return
+	}
+	if err := w.source.store.SaveResourceVersion(ctx, w.client.ClusterUID, w.key, applied); err != nil {
+		w.logger.Warn().Err(err).Msg("failed to save resource version")
+		return
+	}
+	w.mu.Lock()
+	w.savedRV = applied
+	w.mu.Unlock()
+}
+
+// stubList builds a list of placeholder objects for the stored rows, so the
+// informer knows which objects exist and can report their deletion.
+func (w *resourceWatch) stubList(keys []internal.ObjectKey, resourceVersion string) (runtime.Object, error) {
+	items := make([]runtime.Object, 0, len(keys))
+	for _, key := range keys {
+		obj := w.resource.object.DeepCopyObject()
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		accessor.SetUID(types.UID(key.UID))
+		accessor.SetNamespace(key.Namespace)
+		accessor.SetName(key.Name)
+		accessor.SetAnnotations(map[string]string{stubAnnotation: "true"})
+		items = append(items, obj)
+	}
+
+	list := w.resource.newList()
+	if err := meta.SetList(list, items); err != nil {
+		return nil, err
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, err
+	}
+	listMeta.SetResourceVersion(resourceVersion)
+	return list, nil
+}
+
+func isStub(obj runtime.Object) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	_, ok := accessor.GetAnnotations()[stubAnnotation]
+	return ok
+}
+
+func resourceVersion(obj interface{}) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetResourceVersion()
+}
+
+// resumingListWatch serves its first List from resumeList when set, so the
+// reflector starts watching from a saved resourceVersion instead of listing.
+// Later lists, e.g. after the resourceVersion expired, go to the API server.
+// Every call to the API server carries the resource's selector.
+type resumingListWatch struct {
+	list       cache.ListWithContextFunc
+	watch      cache.WatchFuncWithContext
+	selector   metav1.ListOptions
+	resumeList runtime.Object
+}
+
+func (lw *resumingListWatch) List(opts metav1.ListOptions) (runtime.Object, error) {
+	return lw.ListWithContext(context.Background(), opts)
+}
+
+func (lw *resumingListWatch) ListWithContext(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+	if lw.resumeList != nil {
+		list := lw.resumeList
+		lw.resumeList = nil
+		return list, nil
+	}
+	opts.LabelSelector, opts.FieldSelector = lw.selector.LabelSelector, lw.selector.FieldSelector
+	return lw.list(ctx, opts)
+}
+
+func (lw *resumingListWatch) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return lw.WatchWithContext(context.Background(), opts)
+}
+
+func (lw *resumingListWatch) WatchWithContext(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	opts.LabelSelector, opts.FieldSelector = lw.selector.LabelSelector, lw.selector.FieldSelector
+	return lw.watch(ctx, opts)
+}
+
+// IsWatchListSemanticsUnSupported keeps the reflector on the List+Watch path,
+// which is what lets the first List be served from stored rows.
+func (lw *resumingListWatch) IsWatchListSemanticsUnSupported() bool {
+	return true
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/cloudquery/plugin-sdk/v4/schema"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/Genos0820/cq-k8s-custom/internal"
+)
+
+// watchedResource describes how watch mode lists, watches and maps one
+// resource type onto its table.
+type watchedResource struct {
+	resource string
+	table    func() *schema.Table
+	// namespaced resources are watched one namespace at a time when the
+	// context's namespace scope names them.
+	namespaced bool
+	// object is an empty instance of the watched type
+	object  runtime.Object
+	newList func() runtime.Object
+	// list and watch take the namespace to watch, or "" for all of them;
+	// cluster-scoped resources ignore it.
+	list  func(c *internal.Client, namespace string) cache.ListWithContextFunc
+	watch func(c *internal.Client, namespace string) cache.WatchFuncWithContext
+	row   func(c *internal.Client, obj runtime.Object) map[string]interface{}
+}
+
+var watchedResources = []watchedResource{
+	{
+		resource: "namespaces",
+		table:    NamespacesTable,
+		object:   &corev1.Namespace{},
+		newList:  func() runtime.Object { return &corev1.NamespaceList{} },
+		list: func(c *internal.Client, _ string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.Clientset.CoreV1().Namespaces().List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, _ string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Clientset.CoreV1().Namespaces().Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return namespaceRow(c, obj.(*corev1.Namespace))
+		},
+	},
+	{
+		resource: "nodes",
+		table:    NodesTable,
+		object:   &corev1.Node{},
+		newList:  func() runtime.Object { return &corev1.NodeList{} },
+		list: func(c *internal.Client, _ string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.Clientset.CoreV1().Nodes().List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, _ string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Clientset.CoreV1().Nodes().Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return nodeRow(c, obj.(*corev1.Node))
+		},
+	},
+	{
+		resource:   "pods",
+		namespaced: true,
+		table:      PodsTable,
+		object:     &corev1.Pod{},
+		newList:    func() runtime.Object { return &corev1.PodList{} },
+		list: func(c *internal.Client, namespace string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.Clientset.CoreV1().Pods(namespace).List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, namespace string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return podRow(c, obj.(*corev1.Pod))
+		},
+	},
+	{
+		resource:   "deployments",
+		namespaced: true,
+		table:      DeploymentsTable,
+		object:     &appsv1.Deployment{},
+		newList:    func() runtime.Object { return &appsv1.DeploymentList{} },
+		list: func(c *internal.Client, namespace string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.Clientset.AppsV1().Deployments(namespace).List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, namespace string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Clientset.AppsV1().Deployments(namespace).Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return deploymentRow(c, obj.(*appsv1.Deployment))
+		},
+	},
+	{
+		resource:   "services",
+		namespaced: true,
+		table:      ServicesTable,
+		object:     &corev1.Service{},
+		newList:    func() runtime.Object { return &corev1.ServiceList{} },
+		list: func(c *internal.Client, namespace string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.Clientset.CoreV1().Services(namespace).List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, namespace string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.Clientset.CoreV1().Services(namespace).Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return serviceRow(c, obj.(*corev1.Service))
+		},
+	},
+	{
+		resource: "crds",
+		table:    CustomResourcesTable,
+		object:   &apiextensionsv1.CustomResourceDefinition{},
+		newList:  func() runtime.Object { return &apiextensionsv1.CustomResourceDefinitionList{} },
+		list: func(c *internal.Client, _ string) cache.ListWithContextFunc {
+			return func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+				return c.ApiextensionsClientset.ApiextensionsV1().CustomResourceDefinitions().List(ctx, opts)
+			}
+		},
+		watch: func(c *internal.Client, _ string) cache.WatchFuncWithContext {
+			return func(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+				return c.ApiextensionsClientset.ApiextensionsV1().CustomResourceDefinitions().Watch(ctx, opts)
+			}
+		},
+		row: func(c *internal.Client, obj runtime.Object) map[string]interface{} {
+			return crdRow(c, obj.(*apiextensionsv1.CustomResourceDefinition))
+		},
+	},
+}